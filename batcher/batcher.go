@@ -2,10 +2,33 @@
 package batcher
 
 import (
+	"context"
+	"errors"
+	"sort"
 	"sync"
 	"time"
 )
 
+// ErrQueueFull is the error returned by Run when the batcher is configured to Reject excess work and
+// the maximum number of queued items has been reached.
+var ErrQueueFull = errors.New("batcher queue is full")
+
+// ErrClosed is the error returned by Run when the batcher has been closed.
+var ErrClosed = errors.New("batcher is closed")
+
+// ErrBatchTimeout is the error returned by Run when the batcher is configured with a work timeout
+// and the work function did not finish processing the batch in time.
+var ErrBatchTimeout = errors.New("timed out waiting for batch to finish")
+
+// QueueMode is the type used to specify how the batcher behaves when the maximum number of queued items
+// has been reached.
+type QueueMode int
+
+const (
+	Block  QueueMode = iota // Block indicates Run should wait until space frees up in the queue.
+	Reject                  // Reject indicates Run should return ErrQueueFull immediately.
+)
+
 type work struct {
 	param  interface{}
 	future chan error
@@ -15,12 +38,28 @@ type work struct {
 type Batcher struct {
 	timeout   time.Duration
 	prefilter func(interface{}) error
+	queue     chan struct{}
+	queueMode QueueMode
+	dedupKey  func(interface{}) string
+	workLimit time.Duration
+	preFlush  func([]interface{})
+	priority  func(interface{}) int
+	inline    bool
 
 	lock         sync.Mutex
+	closed       bool
 	submit       chan *work
-	doWork       func([]interface{}) error
+	doWork       func(context.Context, []interface{}) error
 	batchCounter sync.WaitGroup
 	flushTimer   *time.Timer
+	current      *inlineBatch
+	stats        flushStats
+}
+
+// inlineBatch is a batch being collected by a batcher configured WithInlineExecution.
+type inlineBatch struct {
+	works []*work
+	flush chan struct{} // closed to flush the batch before its timeout
 }
 
 // New constructs a new batcher that will batch all calls to Run that occur within
@@ -28,6 +67,16 @@ type Batcher struct {
 // function must be safe to run concurrently with itself as this may occur, especially
 // when the doWork function is slow, or the timeout is small.
 func New(timeout time.Duration, doWork func([]interface{}) error) *Batcher {
+	return NewCtx(timeout, func(_ context.Context, params []interface{}) error {
+		return doWork(params)
+	})
+}
+
+// NewCtx is like New, but the doWork function is also passed a context. If the batcher is configured
+// WithWorkTimeout, the context is cancelled when a batch times out (and context.Cause returns ErrBatchTimeout),
+// so that work which respects it can stop instead of running on in the background; otherwise it is never
+// cancelled.
+func NewCtx(timeout time.Duration, doWork func(ctx context.Context, params []interface{}) error) *Batcher {
 	return &Batcher{
 		timeout: timeout,
 		doWork:  doWork,
@@ -38,14 +87,25 @@ func New(timeout time.Duration, doWork func([]interface{}) error) *Batcher {
 // including it in a batch with other calls to Run that occur within the
 // specified timeout. It is safe to call Run concurrently on the same batcher.
 func (b *Batcher) Run(param interface{}) error {
+	if b.isClosed() {
+		return ErrClosed
+	}
+
 	if b.prefilter != nil {
 		if err := b.prefilter(param); err != nil {
 			return err
 		}
 	}
 
+	if b.queue != nil {
+		if err := b.enqueue(); err != nil {
+			return err
+		}
+		defer b.dequeue()
+	}
+
 	if b.timeout == 0 {
-		return b.doWork([]interface{}{param})
+		return b.runImmediately(param)
 	}
 
 	w := &work{
@@ -53,7 +113,9 @@ func (b *Batcher) Run(param interface{}) error {
 		future: make(chan error, 1),
 	}
 
-	b.submitWork(w)
+	if err := b.submitWork(w); err != nil {
+		return err
+	}
 
 	return <-w.future
 }
@@ -67,10 +129,166 @@ func (b *Batcher) Prefilter(filter func(interface{}) error) {
 	b.prefilter = filter
 }
 
-func (b *Batcher) submitWork(w *work) {
+// WithMaxQueuedItems limits the number of items which may be pending at once, counting from when Run is called
+// until the batch containing the item has finished executing. Once the limit is reached, further calls to Run
+// either block until space frees up or immediately return ErrQueueFull, depending on the mode. This provides
+// backpressure when the work function is slow, rather than letting batches pile up without bound. Values of
+// n less than 1 are silently ignored. The limit cannot safely be specified for a batcher if Run has already
+// been invoked.
+func (b *Batcher) WithMaxQueuedItems(n int, mode QueueMode) *Batcher {
+	if n < 1 {
+		return b
+	}
+	b.queue = make(chan struct{}, n)
+	b.queueMode = mode
+	return b
+}
+
+// WithDedupKey configures the batcher to collapse items with the same key into a single item, so that within
+// each batch the work function only sees the first item submitted for each key. Since the work function's
+// error is returned to every caller in the batch, callers who submitted duplicates still get the result of
+// the batch. The key function must be concurrency-safe. It cannot safely be specified for a batcher if Run
+// has already been invoked.
+func (b *Batcher) WithDedupKey(key func(interface{}) string) *Batcher {
+	b.dedupKey = key
+	return b
+}
+
+// WithWorkTimeout limits how long the work function may take to process each batch. If it has not returned
+// within d, every call to Run in that batch returns ErrBatchTimeout. The work function cannot be killed, so it
+// keeps running in its own goroutine (and its eventual result is discarded); construct the batcher with NewCtx
+// to have its context cancelled at the timeout, otherwise a batch which never finishes leaks its goroutine.
+// Values of d less than or equal to 0 disable the timeout. It cannot safely be specified for a batcher if Run
+// has already been invoked.
+func (b *Batcher) WithWorkTimeout(d time.Duration) *Batcher {
+	b.workLimit = d
+	return b
+}
+
+// WithPreFlush configures the batcher to call the given function synchronously with the items of each batch,
+// just before the work function is called on them (after any deduplication), whether the batch is flushed
+// by its timeout expiring or by Shutdown or Close. This is intended for logging and metrics; the function is
+// passed the same slice as the work function, so it must not modify it. It must be concurrency-safe, and
+// cannot safely be specified for a batcher if Run has already been invoked.
+func (b *Batcher) WithPreFlush(fn func(items []interface{})) *Batcher {
+	b.preFlush = fn
+	return b
+}
+
+// WithItemPriority configures the batcher to sort the items of each batch by descending priority (as returned by
+// the given function) before passing them to the work function. Items with the same priority keep the order in
+// which they were submitted. Since every caller in a batch receives the batch's result, reordering the items
+// does not affect what each caller receives. The priority function must be concurrency-safe. It cannot safely be
+// specified for a batcher if Run has already been invoked.
+func (b *Batcher) WithItemPriority(priority func(item interface{}) int) *Batcher {
+	b.priority = priority
+	return b
+}
+
+// WithInlineExecution configures the batcher to execute each batch on the goroutine of the first caller of Run
+// in the batch, rather than in a new goroutine of its own. That caller waits out the batch's timeout (or for
+// Shutdown or Close) as it would anyway, and then runs the work function and hands the result to the other
+// callers itself. This avoids creating a goroutine per batch, which can reduce latency and overhead when
+// batches are small and frequent, at the cost of that caller also waiting for the result of every other
+// caller's work. Since the work function then runs on the goroutine of a caller, it must not itself call Run
+// on the same batcher. It cannot safely be specified for a batcher if Run has already been invoked.
+func (b *Batcher) WithInlineExecution() *Batcher {
+	b.inline = true
+	return b
+}
+
+// runWork runs the work function on a batch, enforcing the work timeout if one is configured.
+func (b *Batcher) runWork(params []interface{}) error {
+	if b.preFlush != nil {
+		b.preFlush(params)
+	}
+
+	if b.workLimit <= 0 {
+		ret := b.doWork(context.Background(), params)
+		b.stats.record(len(params), ret)
+		return ret
+	}
+
+	ret := b.runWorkWithTimeout(params)
+	b.stats.record(len(params), ret)
+	return ret
+}
+
+// runWorkWithTimeout runs the work function on a batch, giving up once the work timeout passes. The work's
+// context is only cancelled once the timeout has been chosen, so work which returns as soon as it is cancelled
+// can never have its result mistaken for the batch's.
+func (b *Batcher) runWorkWithTimeout(params []interface{}) error {
+	ctx, cancel := context.WithCancelCause(context.Background())
+	defer cancel(nil)
+
+	result := make(chan error, 1)
+	go func() {
+		result <- b.doWork(ctx, params)
+	}()
+
+	timer := time.NewTimer(b.workLimit)
+	defer timer.Stop()
+	select {
+	case ret := <-result:
+		return ret
+	case <-timer.C:
+		cancel(ErrBatchTimeout)
+		return ErrBatchTimeout
+	}
+}
+
+func (b *Batcher) enqueue() error {
+	if b.queueMode == Reject {
+		select {
+		case b.queue <- struct{}{}:
+			return nil
+		default:
+			return ErrQueueFull
+		}
+	}
+
+	b.queue <- struct{}{}
+	return nil
+}
+
+func (b *Batcher) dequeue() {
+	<-b.queue
+}
+
+func (b *Batcher) isClosed() bool {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	return b.closed
+}
+
+// runImmediately runs the work function on a batch of just the given param, for a batcher with no timeout. The
+// run is counted as an executing batch, so that Close and Shutdown wait for it too.
+func (b *Batcher) runImmediately(param interface{}) error {
+	b.lock.Lock()
+	if b.closed {
+		b.lock.Unlock()
+		return ErrClosed
+	}
+	b.batchCounter.Add(1)
+	b.lock.Unlock()
+	defer b.batchCounter.Done()
+
+	return b.runWork([]interface{}{param})
+}
+
+func (b *Batcher) submitWork(w *work) error {
+	if b.inline {
+		return b.submitInline(w)
+	}
+
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.closed {
+		return ErrClosed
+	}
+
 	// kick off a new batch if needed
 	if b.submit == nil {
 		b.batchCounter.Add(1)
@@ -81,20 +299,97 @@ func (b *Batcher) submitWork(w *work) {
 
 	// then add this work to the current batch
 	b.submit <- w
+	return nil
+}
+
+// submitInline adds work to the current inline batch, starting a new batch and executing it if needed.
+func (b *Batcher) submitInline(w *work) error {
+	b.lock.Lock()
+
+	if b.closed {
+		b.lock.Unlock()
+		return ErrClosed
+	}
+
+	batch := b.current
+	leader := batch == nil
+	if leader {
+		b.batchCounter.Add(1)
+		batch = &inlineBatch{flush: make(chan struct{})}
+		b.current = batch
+	}
+	batch.works = append(batch.works, w)
+	b.lock.Unlock()
+
+	if leader {
+		b.runInline(batch)
+	}
+	return nil
+}
+
+// runInline waits for an inline batch to be flushed, and then executes it.
+func (b *Batcher) runInline(batch *inlineBatch) {
+	defer b.batchCounter.Done()
+
+	timer := time.NewTimer(b.timeout)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+	case <-batch.flush:
+	}
+
+	b.lock.Lock()
+	if b.current == batch {
+		b.current = nil
+	}
+	works := batch.works
+	b.lock.Unlock()
+
+	b.execute(works)
 }
 
 func (b *Batcher) batch(input <-chan *work) {
 	defer b.batchCounter.Done()
 
+	var works []*work
+	for work := range input {
+		works = append(works, work)
+	}
+
+	b.execute(works)
+}
+
+// execute runs the work function on a batch of work, and hands the result to every caller in the batch.
+func (b *Batcher) execute(works []*work) {
 	var params []interface{}
 	var futures []chan error
+	var seen map[string]bool
 
-	for work := range input {
-		params = append(params, work.param)
+	if b.dedupKey != nil {
+		seen = make(map[string]bool)
+	}
+
+	for _, work := range works {
 		futures = append(futures, work.future)
+		if seen != nil {
+			key := b.dedupKey(work.param)
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		params = append(params, work.param)
 	}
 
-	ret := b.doWork(params)
+	if b.priority != nil {
+		priorities := make([]int, len(params))
+		for i, param := range params {
+			priorities[i] = b.priority(param)
+		}
+		sort.Stable(byPriority{params, priorities})
+	}
+
+	ret := b.runWork(params)
 
 	for _, future := range futures {
 		future <- ret
@@ -102,6 +397,19 @@ func (b *Batcher) batch(input <-chan *work) {
 	}
 }
 
+// byPriority sorts a batch's params by descending priority, keeping the priorities in step.
+type byPriority struct {
+	params     []interface{}
+	priorities []int
+}
+
+func (p byPriority) Len() int           { return len(p.params) }
+func (p byPriority) Less(i, j int) bool { return p.priorities[i] > p.priorities[j] }
+func (p byPriority) Swap(i, j int) {
+	p.params[i], p.params[j] = p.params[j], p.params[i]
+	p.priorities[i], p.priorities[j] = p.priorities[j], p.priorities[i]
+}
+
 // Shutdown flushes and executes any pending batches. If wait is true, it also waits for the pending batches
 // to finish executing before it returns. This can be used to avoid waiting for the timeout to expire when
 // gracefully shutting down your application. Calling Run at any point after calling Shutdown will lead to
@@ -114,10 +422,41 @@ func (b *Batcher) Shutdown(wait bool) {
 	}
 }
 
+// Close stops the batcher from accepting new work, flushes any pending batch, and waits for all executing
+// batches to finish (including the work of calls to Run on a batcher with a timeout of 0, which are executed
+// immediately rather than batched). Once Close has been called, Run returns ErrClosed. If the context expires before the
+// pending batches finish executing, Close returns the context's error without waiting any further; the
+// batches still run to completion in the background.
+func (b *Batcher) Close(ctx context.Context) error {
+	b.lock.Lock()
+	b.closed = true
+	b.lock.Unlock()
+
+	b.flushCurrentBatch()
+
+	done := make(chan struct{})
+	go func() {
+		b.batchCounter.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func (b *Batcher) flushCurrentBatch() {
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
+	if b.current != nil {
+		close(b.current.flush)
+		b.current = nil
+	}
+
 	if b.submit == nil {
 		return
 	}
@@ -1,7 +1,9 @@
 package batcher
 
 import (
+	"context"
 	"errors"
+	"runtime"
 	"sync"
 	"sync/atomic"
 	"testing"
@@ -93,6 +95,101 @@ func TestShutdownEmpty(t *testing.T) {
 	b.Shutdown(true)
 }
 
+func TestBatcherClose(t *testing.T) {
+	total := int32(0)
+	b := New(1*time.Second, func(params []interface{}) error {
+		atomic.AddInt32(&total, int32(len(params)))
+		return nil
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			if err := b.Run(nil); err != nil {
+				t.Error(err)
+			}
+			wg.Done()
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+
+	start := time.Now()
+	if err := b.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("pending batch was not flushed")
+	}
+	if atomic.LoadInt32(&total) != 5 {
+		t.Error("pending items were not processed:", total)
+	}
+	wg.Wait()
+
+	if err := b.Run(nil); err != ErrClosed {
+		t.Error(err)
+	}
+
+	b = New(0, returnsSuccess)
+	if err := b.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := b.Run(nil); err != ErrClosed {
+		t.Error(err)
+	}
+
+	// without a timeout, work already running is still waited for
+	release := make(chan struct{})
+	started := make(chan struct{})
+	b = New(0, func(params []interface{}) error {
+		close(started)
+		<-release
+		return nil
+	})
+	go func() {
+		if err := b.Run(nil); err != nil {
+			t.Error(err)
+		}
+	}()
+	<-started
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); err != context.DeadlineExceeded {
+		t.Error("Close did not wait for running work", err)
+	}
+	close(release)
+	if err := b.Close(context.Background()); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherCloseContext(t *testing.T) {
+	release := make(chan struct{})
+	b := New(1*time.Millisecond, func(params []interface{}) error {
+		<-release
+		return nil
+	})
+
+	wg := &sync.WaitGroup{}
+	wg.Add(1)
+	go func() {
+		if err := b.Run(nil); err != nil {
+			t.Error(err)
+		}
+		wg.Done()
+	}()
+	time.Sleep(5 * time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := b.Close(ctx); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	close(release)
+	wg.Wait()
+}
+
 func TestBatcherError(t *testing.T) {
 	b := New(10*time.Millisecond, returnsError)
 
@@ -158,6 +255,347 @@ func TestBatcherMultipleBatches(t *testing.T) {
 	}
 }
 
+func TestBatcherMaxQueuedItemsBlock(t *testing.T) {
+	var inFlight, maxInFlight int32
+
+	b := New(1*time.Millisecond, func(params []interface{}) error {
+		n := atomic.AddInt32(&inFlight, int32(len(params)))
+		for {
+			max := atomic.LoadInt32(&maxInFlight)
+			if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+				break
+			}
+		}
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&inFlight, -int32(len(params)))
+		return nil
+	}).WithMaxQueuedItems(3, Block)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			if err := b.Run(nil); err != nil {
+				t.Error(err)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+
+	if maxInFlight > 3 {
+		t.Error("too many items in flight:", maxInFlight)
+	}
+}
+
+func TestBatcherMaxQueuedItemsReject(t *testing.T) {
+	release := make(chan struct{})
+
+	b := New(1*time.Millisecond, func(params []interface{}) error {
+		<-release
+		return nil
+	}).WithMaxQueuedItems(2, Reject)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			if err := b.Run(nil); err != nil {
+				t.Error(err)
+			}
+			wg.Done()
+		}()
+	}
+
+	// wait for the two slots to be taken
+	for len(b.queue) < 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if err := b.Run(nil); err != ErrQueueFull {
+		t.Error(err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if err := b.Run(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherDedupKey(t *testing.T) {
+	var lock sync.Mutex
+	var batches [][]interface{}
+
+	b := New(10*time.Millisecond, func(params []interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		batches = append(batches, params)
+		return errSomeError
+	}).WithDedupKey(func(param interface{}) string {
+		return param.(string)
+	})
+
+	wg := &sync.WaitGroup{}
+	for _, key := range []string{"a", "b", "a", "c", "b", "a"} {
+		wg.Add(1)
+		go func(key string) {
+			if err := b.Run(key); err != errSomeError {
+				t.Error(err)
+			}
+			wg.Done()
+		}(key)
+	}
+	wg.Wait()
+
+	if len(batches) != 1 {
+		t.Fatal("wrong number of batches", len(batches))
+	}
+	seen := make(map[interface{}]bool)
+	for _, param := range batches[0] {
+		if seen[param] {
+			t.Error("duplicate item in batch:", param)
+		}
+		seen[param] = true
+	}
+	if len(seen) != 3 {
+		t.Error("incorrect items in batch:", batches[0])
+	}
+}
+
+func TestBatcherPreFlush(t *testing.T) {
+	var lock sync.Mutex
+	var events []string
+
+	b := New(10*time.Millisecond, func(params []interface{}) error {
+		lock.Lock()
+		defer lock.Unlock()
+		events = append(events, "work")
+		return nil
+	}).WithPreFlush(func(items []interface{}) {
+		lock.Lock()
+		defer lock.Unlock()
+		sum := 0
+		for _, item := range items {
+			sum += item.(int)
+		}
+		if len(items) != 4 || sum != 10 {
+			t.Error("incorrect items", items)
+		}
+		events = append(events, "preflush")
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 1; i <= 4; i++ {
+		wg.Add(1)
+		go func(i int) {
+			if err := b.Run(i); err != nil {
+				t.Error(err)
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(events) != 2 || events[0] != "preflush" || events[1] != "work" {
+		t.Error("incorrect events", events)
+	}
+}
+
+func TestBatcherItemPriority(t *testing.T) {
+	type item struct {
+		name     string
+		priority int
+	}
+
+	var batch []interface{}
+	b := New(10*time.Millisecond, func(params []interface{}) error {
+		batch = params
+		return errSomeError
+	}).WithItemPriority(func(param interface{}) int {
+		return param.(item).priority
+	})
+
+	items := []item{{"low", 1}, {"high", 10}, {"medium", 5}, {"also-high", 10}}
+	wg := &sync.WaitGroup{}
+	for _, it := range items {
+		wg.Add(1)
+		go func(it item) {
+			if err := b.Run(it); err != errSomeError {
+				t.Error(err)
+			}
+			wg.Done()
+		}(it)
+	}
+	wg.Wait()
+
+	if len(batch) != len(items) {
+		t.Fatal("wrong batch", batch)
+	}
+	for i := 1; i < len(batch); i++ {
+		if batch[i].(item).priority > batch[i-1].(item).priority {
+			t.Error("batch not sorted by priority", batch)
+		}
+	}
+	if batch[3].(item).name != "low" {
+		t.Error("batch not sorted by priority", batch)
+	}
+}
+
+func TestBatcherInlineExecution(t *testing.T) {
+	const callers = 5
+	var goroutines int
+	var batch []interface{}
+	b := New(20*time.Millisecond, func(params []interface{}) error {
+		goroutines = runtime.NumGoroutine()
+		batch = params
+		return errSomeError
+	}).WithInlineExecution()
+
+	base := runtime.NumGoroutine()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			if err := b.Run(i); err != errSomeError {
+				t.Error(err)
+			}
+			wg.Done()
+		}(i)
+	}
+	wg.Wait()
+
+	if len(batch) != callers {
+		t.Error("wrong batch", batch)
+	}
+	// the work ran on one of the callers' goroutines, with no goroutine for the batch itself
+	if goroutines > base+callers {
+		t.Error("extra goroutines spawned", goroutines-base-callers)
+	}
+
+	// shutting down flushes an inline batch immediately
+	done := make(chan error)
+	b = New(time.Minute, returnsSuccess).WithInlineExecution()
+	go func() { done <- b.Run(nil) }()
+	time.Sleep(10 * time.Millisecond)
+	b.Shutdown(true)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherWorkTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	b := New(5*time.Millisecond, func(params []interface{}) error {
+		<-release
+		return nil
+	}).WithWorkTimeout(20 * time.Millisecond)
+
+	start := time.Now()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			if err := b.Run(nil); err != ErrBatchTimeout {
+				t.Error(err)
+			}
+			wg.Done()
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Error("incorrect timeout", elapsed)
+	}
+
+	b = New(5*time.Millisecond, returnsError).WithWorkTimeout(20 * time.Millisecond)
+	if err := b.Run(nil); err != errSomeError {
+		t.Error(err)
+	}
+}
+
+func TestBatcherWorkTimeoutCtx(t *testing.T) {
+	causes := make(chan error, 1)
+	b := NewCtx(5*time.Millisecond, func(ctx context.Context, params []interface{}) error {
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	}).WithWorkTimeout(20 * time.Millisecond)
+
+	if err := b.Run(nil); err != ErrBatchTimeout {
+		t.Error(err)
+	}
+	select {
+	case cause := <-causes:
+		if cause != ErrBatchTimeout {
+			t.Error("wrong cause", cause)
+		}
+	case <-time.After(time.Second):
+		t.Error("work was not cancelled at the timeout")
+	}
+
+	// without a work timeout the context is never cancelled
+	b = NewCtx(5*time.Millisecond, func(ctx context.Context, params []interface{}) error {
+		return ctx.Err()
+	})
+	if err := b.Run(nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBatcherStats(t *testing.T) {
+	b := New(20*time.Millisecond, func(params []interface{}) error {
+		if len(params) == 4 {
+			return errSomeError
+		}
+		return nil
+	})
+	if stats := b.Stats(); stats != (BatchStats{}) {
+		t.Error("stats before any flush", stats)
+	}
+
+	for _, size := range []int{1, 4, 10} {
+		wg := &sync.WaitGroup{}
+		for i := 0; i < size; i++ {
+			wg.Add(1)
+			go func() {
+				b.Run(nil)
+				wg.Done()
+			}()
+		}
+		wg.Wait()
+	}
+
+	stats := b.Stats()
+	if stats.Flushes != 3 || stats.AverageSize != 5 {
+		t.Error("wrong batch sizes", stats)
+	}
+	if stats.ErrorRate < 0.33 || stats.ErrorRate > 0.34 {
+		t.Error("wrong error rate", stats)
+	}
+	if stats.FlushesPerSec <= 0 || stats.FlushesPerSec > 50 {
+		t.Error("wrong flush frequency", stats)
+	}
+
+	// only the most recent flushes are counted
+	failing := true
+	b = New(0, func(params []interface{}) error {
+		if failing {
+			return errSomeError
+		}
+		return nil
+	}).WithStatsWindow(2)
+	b.Run(nil)
+	failing = false
+	b.Run(nil)
+	b.Run(nil)
+	if stats := b.Stats(); stats.Flushes != 2 || stats.ErrorRate != 0 || stats.AverageSize != 1 {
+		t.Error("window not applied", stats)
+	}
+}
+
 func ExampleBatcher() {
 	b := New(10*time.Millisecond, func(params []interface{}) error {
 		// do something with the batch of parameters
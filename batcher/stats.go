@@ -0,0 +1,100 @@
+package batcher
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultStatsWindow is the number of flushes Stats is computed over unless configured WithStatsWindow.
+const defaultStatsWindow = 100
+
+// BatchStats summarizes the most recent flushes of a batcher, as returned by Stats.
+type BatchStats struct {
+	Flushes       int     // the number of flushes the statistics cover, at most the window size
+	AverageSize   float64 // the average number of items passed to the work function per flush
+	FlushesPerSec float64 // the rate of flushes between the first and last covered, or 0 for fewer than two
+	ErrorRate     float64 // the fraction of flushes for which the work function returned an error
+}
+
+// WithStatsWindow configures the number of most recent flushes that Stats is computed over (100 by default).
+// Values of n less than 1 are silently ignored. It cannot safely be specified for a batcher if Run has
+// already been invoked.
+func (b *Batcher) WithStatsWindow(n int) *Batcher {
+	if n < 1 {
+		return b
+	}
+	b.stats.window = n
+	return b
+}
+
+// Stats returns statistics computed over the batcher's most recent flushes, for monitoring how well work is
+// being batched. Each execution of the work function counts as a flush, measured by the number of items it
+// is passed (after any deduplication). It is safe to call Stats concurrently with Run.
+func (b *Batcher) Stats() BatchStats {
+	return b.stats.snapshot()
+}
+
+// flushRecord is what the statistics keep about each flush.
+type flushRecord struct {
+	size   int
+	at     time.Time
+	failed bool
+}
+
+// flushStats keeps a ring buffer of the most recent flushes.
+type flushStats struct {
+	window int
+
+	lock    sync.Mutex
+	records []flushRecord
+	next    int // the index in records to overwrite once it is full
+}
+
+func (s *flushStats) record(size int, err error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	window := s.window
+	if window == 0 {
+		window = defaultStatsWindow
+	}
+	record := flushRecord{size: size, at: time.Now(), failed: err != nil}
+	if len(s.records) < window {
+		s.records = append(s.records, record)
+		return
+	}
+	s.records[s.next] = record
+	s.next = (s.next + 1) % window
+}
+
+func (s *flushStats) snapshot() BatchStats {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	stats := BatchStats{Flushes: len(s.records)}
+	if len(s.records) == 0 {
+		return stats
+	}
+
+	var items, failures int
+	first, last := s.records[0].at, s.records[0].at
+	for _, record := range s.records {
+		items += record.size
+		if record.failed {
+			failures++
+		}
+		if record.at.Before(first) {
+			first = record.at
+		}
+		if record.at.After(last) {
+			last = record.at
+		}
+	}
+
+	stats.AverageSize = float64(items) / float64(len(s.records))
+	stats.ErrorRate = float64(failures) / float64(len(s.records))
+	if span := last.Sub(first); len(s.records) > 1 && span > 0 {
+		stats.FlushesPerSec = float64(len(s.records)-1) / span.Seconds()
+	}
+	return stats
+}
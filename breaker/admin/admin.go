@@ -0,0 +1,66 @@
+// Package admin provides an HTTP handler for inspecting and controlling a circuit-breaker, so that the
+// breaker package itself does not depend on net/http.
+package admin
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/eapache/go-resiliency/breaker"
+)
+
+// Status is the JSON body returned by the handler.
+type Status struct {
+	State                string `json:"state"`
+	ConsecutiveFailures  int    `json:"consecutive_failures"`
+	ConsecutiveSuccesses int    `json:"consecutive_successes"`
+	OpenRejected         int    `json:"open_rejected"`
+	HalfOpenRejected     int    `json:"half_open_rejected"`
+}
+
+// Handler returns an http.Handler for the given breaker. A GET request returns the breaker's current Status
+// as JSON. A POST request with the form value "action" set to "trip" or "reset" calls the breaker's Trip or
+// Reset method respectively, and then returns the new Status. Any other request is an error.
+func Handler(b *breaker.Breaker) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		switch req.Method {
+		case http.MethodGet, http.MethodHead:
+		case http.MethodPost:
+			switch action := req.FormValue("action"); action {
+			case "trip":
+				b.Trip()
+			case "reset":
+				b.Reset()
+			default:
+				http.Error(w, "unknown action "+action, http.StatusBadRequest)
+				return
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		counts := b.Counts()
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(Status{
+			State:                stateName(b.GetState()),
+			ConsecutiveFailures:  counts.ConsecutiveFailures,
+			ConsecutiveSuccesses: counts.ConsecutiveSuccesses,
+			OpenRejected:         counts.OpenRejected,
+			HalfOpenRejected:     counts.HalfOpenRejected,
+		})
+	})
+}
+
+func stateName(state breaker.State) string {
+	switch state {
+	case breaker.Closed:
+		return "closed"
+	case breaker.Open:
+		return "open"
+	case breaker.HalfOpen:
+		return "half-open"
+	}
+	return "unknown"
+}
@@ -0,0 +1,79 @@
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/breaker"
+)
+
+func getStatus(t *testing.T, server *httptest.Server) Status {
+	resp, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var status Status
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		t.Fatal(err)
+	}
+	return status
+}
+
+func postAction(t *testing.T, server *httptest.Server, action string) *http.Response {
+	resp, err := http.PostForm(server.URL, url.Values{"action": {action}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	return resp
+}
+
+func TestHandler(t *testing.T) {
+	b := breaker.New(3, 1, time.Minute)
+	server := httptest.NewServer(Handler(b))
+	defer server.Close()
+
+	_ = b.Run(func() error { return errors.New("oops") })
+	if status := getStatus(t, server); status.State != "closed" || status.ConsecutiveFailures != 1 {
+		t.Error("wrong status", status)
+	}
+
+	if resp := postAction(t, server, "trip"); resp.StatusCode != http.StatusOK {
+		t.Error(resp.Status)
+	}
+	if b.GetState() != breaker.Open {
+		t.Error("breaker not tripped")
+	}
+	_ = b.Run(func() error { return nil })
+	if status := getStatus(t, server); status.State != "open" || status.ConsecutiveFailures != 0 || status.OpenRejected != 1 {
+		t.Error("wrong status", status)
+	}
+
+	if resp := postAction(t, server, "reset"); resp.StatusCode != http.StatusOK {
+		t.Error(resp.Status)
+	}
+	if b.GetState() != breaker.Closed {
+		t.Error("breaker not reset")
+	}
+
+	if resp := postAction(t, server, "explode"); resp.StatusCode != http.StatusBadRequest {
+		t.Error(resp.Status)
+	}
+	req, _ := http.NewRequest(http.MethodDelete, server.URL, strings.NewReader(""))
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Error(resp.Status)
+	}
+}
@@ -2,7 +2,9 @@
 package breaker
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -12,6 +14,14 @@ import (
 // because the breaker is currently open.
 var ErrBreakerOpen = errors.New("circuit breaker is open")
 
+// ErrTimedOut is the error returned from Run() when the breaker is configured
+// WithHardTimeout and the function does not finish in time.
+var ErrTimedOut = errors.New("circuit breaker timed out waiting for function to finish")
+
+// ErrStreamStalled is the error returned from RunStreaming when the function does not heartbeat within the
+// threshold configured WithSlowCallThreshold.
+var ErrStreamStalled = errors.New("circuit breaker stream stalled without a heartbeat")
+
 // State is a type representing the possible states of a circuit breaker.
 type State uint32
 
@@ -21,20 +31,72 @@ const (
 	HalfOpen
 )
 
+// Outcome is a type representing how a unit of work run with RunWithOutcome should be
+// counted by the circuit-breaker, independently of the error it returns.
+type Outcome int
+
+const (
+	Success Outcome = iota // Success indicates the work should be counted as a success.
+	Failure                // Failure indicates the work should be counted as a failure.
+	Ignore                 // Ignore indicates the work should not be counted at all.
+)
+
+// StateStore is the interface implemented by anything that can share the state of a circuit-breaker
+// between processes (e.g. by persisting it in an external database or cache).
+type StateStore interface {
+	Load() (State, error)
+	Store(State) error
+}
+
 // Breaker implements the circuit-breaker resiliency pattern
 type Breaker struct {
 	errorThreshold, successThreshold int
 	timeout                          time.Duration
+	onFailure                        func(err error, consecutiveFailures int)
+	store                            StateStore
+	storeTTL                         time.Duration
+	onStoreError                     func(error)
+	hardTimeout                      time.Duration
+	probeSelector                    func(ctx context.Context) bool
+	errorWeight                      func(error) float64
+	openError                        func() error
+	failOpen                         bool
+	slowCallThreshold                time.Duration
+	immediateTrip                    func(error) bool
+	halfOpenPenalty                  int
+	halfOpenRetries                  int
 
 	lock              sync.Mutex
 	state             State
+	failing           uint32 // set (atomically) while errors > 0 in the closed state
 	errors, successes int
+	weight            float64 // the weighted sum of the consecutive errors
 	lastError         time.Time
+	lastTripError     error
+	generation        int // incremented every time the breaker opens, so stale timers can be ignored
+	changes           chan State
+	carried           int // the successes kept (WithHalfOpenFailurePenalty) for when the breaker half-opens again
+	transitions       int // incremented on every state change, so the StateStore can tell when it is behind
+
+	storeLock sync.Mutex   // serialises writes to the StateStore, which happen outside lock
+	stored    int          // the value of transitions last written to the StateStore; guarded by storeLock
+	lastLoad  atomic.Int64 // when the StateStore was last loaded, in nanoseconds since the Unix epoch
+
+	openRejected, halfOpenRejected atomic.Int64
+}
+
+// Counts is a snapshot of the counters of a circuit-breaker, as returned by Counts.
+type Counts struct {
+	ConsecutiveFailures  int // the number of consecutive failures recorded while closed
+	ConsecutiveSuccesses int // the number of consecutive successes recorded while half-open
+	OpenRejected         int // the total number of calls rejected because the breaker was open
+	HalfOpenRejected     int // the total number of calls rejected while half-open (by WithProbeSelector)
 }
 
 // New constructs a new circuit-breaker that starts closed.
-// From closed, the breaker opens if "errorThreshold" errors are seen
-// without an error-free period of at least "timeout". From open, the
+// From closed, the breaker opens if "errorThreshold" consecutive errors are seen
+// without an error-free period of at least "timeout" (any success resets the
+// count of consecutive errors). From open, the
 // breaker half-closes after "timeout". From half-open, the breaker closes
 // after "successThreshold" consecutive successes, or opens on a single error.
 func New(errorThreshold, successThreshold int, timeout time.Duration) *Breaker {
@@ -45,17 +107,237 @@ func New(errorThreshold, successThreshold int, timeout time.Duration) *Breaker {
 	}
 }
 
+// WithFailureHandler configures the breaker to call the given function every time it records a
+// failure, passing the error and the number of consecutive failures seen so far (including this one).
+// Panics are reported with an error describing the panic value, and calls reported as a Failure by
+// RunWithOutcome are reported with whatever error they returned (possibly nil). The handler is called synchronously
+// from Run (or from the goroutine started by Go) after the failure has been recorded, so it should be
+// fast and must be safe to call concurrently.
+func (b *Breaker) WithFailureHandler(handler func(err error, consecutiveFailures int)) *Breaker {
+	b.onFailure = handler
+	return b
+}
+
+// WithStateStore configures the breaker to share its state through the given store. Every state transition
+// is written to the store, and before allowing a call the breaker loads the shared state (at most once per
+// "ttl") so that a breaker tripped in one process is observed by the others: if the shared state is Open
+// and the local breaker is not, the local breaker opens too, and then follows its normal timeout from there.
+// The store is never called with the breaker's lock held, so a slow store only delays the call which loads
+// from it or whose result changed the state; concurrent transitions are written one at a time, and only the
+// latest state is written if several happen while a write is in progress. Note that a ttl of 0 loads from the
+// store before every single call. Errors returned by the store are passed to the handler configured with
+// WithStoreErrorHandler (if any) and otherwise ignored, and the local state is used. By default, state is kept
+// purely in memory.
+func (b *Breaker) WithStateStore(store StateStore, ttl time.Duration) *Breaker {
+	b.store = store
+	b.storeTTL = ttl
+	return b
+}
+
+// WithStoreErrorHandler configures the breaker to call the given function with every error returned by the
+// StateStore configured with WithStateStore. It is called synchronously from whichever call loaded from or
+// wrote to the store, so it should be fast and must be safe to call concurrently.
+func (b *Breaker) WithStoreErrorHandler(handler func(err error)) *Breaker {
+	b.onStoreError = handler
+	return b
+}
+
+// WithHardTimeout configures the breaker to enforce a timeout on every call, even if the work function
+// does not accept a context: the work is run in a separate goroutine, and if it does not finish within d
+// then ErrTimedOut is returned and the call is counted as a failure. Note that the work function cannot be
+// killed, so its goroutine keeps running (and any result it eventually produces is discarded); work which
+// regularly overruns will therefore leak goroutines. Values of d less than or equal to 0 disable the timeout.
+func (b *Breaker) WithHardTimeout(d time.Duration) *Breaker {
+	b.hardTimeout = d
+	return b
+}
+
+// WithProbeSelector configures the breaker to only let calls for which the given function returns true
+// through while it is half-open; all others return ErrBreakerOpen as if it were still open. This allows,
+// for example, only health checks (and not user traffic) to probe whether a dependency has recovered. The
+// function is passed the context given to RunCtx (or context.Background() for the other methods).
+func (b *Breaker) WithProbeSelector(selector func(ctx context.Context) bool) *Breaker {
+	b.probeSelector = selector
+	return b
+}
+
+// WithErrorWeights configures the breaker to weight each failure by the value the given function returns for
+// its error (panics are weighted by an error describing the panic value), so that different kinds of failure
+// can trip the breaker at different rates. The breaker then opens once the weighted sum of consecutive failures
+// reaches "errorThreshold" rather than their number; for example with a threshold of 10, failures weighted
+// 5 trip the breaker after 2, while those weighted 0.5 take 20. Failures weighted 0 (or less) are ignored
+// entirely, as with the Ignore outcome. By default every failure has a weight of 1. As without weights, a
+// breaker with an "errorThreshold" of 0 or less is never tripped by failures.
+func (b *Breaker) WithErrorWeights(weight func(error) float64) *Breaker {
+	b.errorWeight = weight
+	return b
+}
+
+// WithImmediateTripOn configures the breaker to open as soon as a call fails with an error for which the given
+// function returns true (panics are passed an error describing the panic value), regardless of the number of
+// consecutive failures. This suits errors after which every future call will fail anyway, e.g. revoked
+// credentials. Other failures still trip the breaker only once they reach "errorThreshold".
+func (b *Breaker) WithImmediateTripOn(catastrophic func(error) bool) *Breaker {
+	b.immediateTrip = catastrophic
+	return b
+}
+
+// WithHalfOpenFailurePenalty configures the breaker so that when a probe fails while half-open, the consecutive
+// successes seen so far are reduced by reduceBy rather than discarded: the breaker re-opens as usual, but the
+// remaining successes count towards "successThreshold" once it half-opens again. This helps a flaky but
+// improving dependency recover faster. By default a failed probe discards all of them. Values of reduceBy less
+// than 1 are silently ignored.
+func (b *Breaker) WithHalfOpenFailurePenalty(reduceBy int) *Breaker {
+	if reduceBy < 1 {
+		return b
+	}
+	b.halfOpenPenalty = reduceBy
+	return b
+}
+
+// WithHalfOpenRetries configures the breaker to immediately retry a failed probe (a call run while the breaker
+// is half-open) up to n times, with no back-off, before counting it; the breaker only re-opens if every retry
+// fails as well, so a single flaky probe does not keep the breaker open. The caller receives the result of the
+// last try. Probes which panic, or whose context is done, are not retried. Values of n less than 1 are silently
+// ignored.
+func (b *Breaker) WithHalfOpenRetries(n int) *Breaker {
+	if n < 1 {
+		return b
+	}
+	b.halfOpenRetries = n
+	return b
+}
+
+// WithOpenError configures the breaker to call the given function for the error to return (instead of
+// ErrBreakerOpen) whenever a call is not run because the breaker is open, so that the error can say which
+// breaker or dependency it came from. The errors returned should wrap ErrBreakerOpen (e.g. using fmt.Errorf
+// with %w), so that callers can still detect them with errors.Is.
+func (b *Breaker) WithOpenError(fn func() error) *Breaker {
+	b.openError = fn
+	return b
+}
+
+// WithFailOpen configures the breaker to fail open: calls which would otherwise be rejected with
+// ErrBreakerOpen (because the breaker is open, or is half-open and the call was not selected as a probe) are
+// run anyway, but their results are not counted, so they cannot keep the breaker flapping. This is intended
+// for non-critical dependencies for which attempting the call is preferable to rejecting it, so that only the
+// breakers of critical dependencies shed load while every breaker still tracks (and reports through GetState)
+// the health of its dependency. By default the breaker fails closed.
+func (b *Breaker) WithFailOpen() *Breaker {
+	b.failOpen = true
+	return b
+}
+
+// WithSlowCallThreshold configures the longest a function run with RunStreaming may go without calling its
+// heartbeat (counting from when it starts) before it is considered stalled. Values of d less than or equal to 0
+// disable the check, which is the default.
+func (b *Breaker) WithSlowCallThreshold(d time.Duration) *Breaker {
+	b.slowCallThreshold = d
+	return b
+}
+
 // Run will either return ErrBreakerOpen immediately if the circuit-breaker is
 // already open, or it will run the given function and pass along its return
 // value. It is safe to call Run concurrently on the same Breaker.
 func (b *Breaker) Run(work func() error) error {
-	state := b.GetState()
+	state, err := b.admit(context.Background())
+	if err != nil {
+		return err
+	}
 
-	if state == Open {
-		return ErrBreakerOpen
+	return b.doWork(context.Background(), state, errorOutcome(work))
+}
+
+// RunCtx is like Run, but the given function is passed a context. If the function fails while the
+// breaker is half-open and the context is done by the time it returns (i.e. the caller gave up on the
+// probe rather than the dependency failing it), the failure is not counted: the breaker stays half-open
+// instead of re-opening, and the next probe decides its fate. It is safe to call RunCtx concurrently on
+// the same Breaker.
+func (b *Breaker) RunCtx(ctx context.Context, work func(context.Context) error) error {
+	state, err := b.admit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return b.doWork(ctx, state, errorOutcome(func() error {
+		return work(ctx)
+	}))
+}
+
+// RunWithOutcome is like Run, but the given function explicitly reports how the call should be counted by
+// the breaker, regardless of the error it returns. For example, a "not found" error can be counted as a
+// Success, or a call which returned no error but took far too long can be counted as a Failure. Calls whose
+// outcome is Ignore are not counted at all. The error is still returned to the caller. Panics are counted as
+// failures, as with Run. It is safe to call RunWithOutcome concurrently on the same Breaker.
+func (b *Breaker) RunWithOutcome(work func() (Outcome, error)) error {
+	state, err := b.admit(context.Background())
+	if err != nil {
+		return err
 	}
 
-	return b.doWork(state, work)
+	return b.doWork(context.Background(), state, work)
+}
+
+// RunWithClassifier is like Run, but uses the given function instead of the breaker's default classification
+// to decide whether the call failed, so that calls sharing a breaker can have different error semantics (e.g.
+// a "not found" error may be benign for one endpoint but a failure for another). The function is called with
+// the error if the work returns one, and the call is counted as a failure if it returns true or as a success
+// otherwise; calls which return nil are always successes, and panics are always failures. The override only
+// affects how this call is counted. It is safe to call RunWithClassifier concurrently on the same Breaker.
+func (b *Breaker) RunWithClassifier(work func() error, classify func(error) bool) error {
+	state, err := b.admit(context.Background())
+	if err != nil {
+		return err
+	}
+
+	return b.doWork(context.Background(), state, func() (Outcome, error) {
+		err := work()
+		if err != nil && classify(err) {
+			return Failure, err
+		}
+		return Success, err
+	})
+}
+
+// RunStreaming is like RunCtx, but for long-lived calls such as streaming RPCs, where a single result at the
+// end says little about the health of the dependency along the way. The function is passed a heartbeat
+// function which it should call whenever it makes progress (e.g. receives a message). If it goes longer than the
+// threshold configured WithSlowCallThreshold without a heartbeat, the stream is considered stalled: its context
+// is cancelled, and once it returns the call is counted as a failure and ErrStreamStalled is returned. Otherwise
+// the call is counted according to its return value, as with RunCtx. It is safe to call RunStreaming (and the
+// heartbeat function) concurrently.
+func (b *Breaker) RunStreaming(ctx context.Context, work func(ctx context.Context, heartbeat func()) error) error {
+	state, err := b.admit(ctx)
+	if err != nil {
+		return err
+	}
+
+	return b.doWork(ctx, state, func() (Outcome, error) {
+		streamCtx, cancel := context.WithCancelCause(ctx)
+		defer cancel(nil)
+
+		var stalled atomic.Bool
+		heartbeat := func() {}
+		if b.slowCallThreshold > 0 {
+			timer := time.AfterFunc(b.slowCallThreshold, func() {
+				stalled.Store(true)
+				cancel(ErrStreamStalled)
+			})
+			defer timer.Stop()
+			heartbeat = func() {
+				timer.Reset(b.slowCallThreshold)
+			}
+		}
+
+		err := work(streamCtx, heartbeat)
+		if stalled.Load() {
+			return Failure, ErrStreamStalled
+		}
+		if err != nil {
+			return Failure, err
+		}
+		return Success, nil
+	})
 }
 
 // Go will either return ErrBreakerOpen immediately if the circuit-breaker is
@@ -64,16 +346,15 @@ func (b *Breaker) Run(work func() error) error {
 // the return value of the function. It is safe to call Go concurrently on the
 // same Breaker.
 func (b *Breaker) Go(work func() error) error {
-	state := b.GetState()
-
-	if state == Open {
-		return ErrBreakerOpen
+	state, err := b.admit(context.Background())
+	if err != nil {
+		return err
 	}
 
 	// errcheck complains about ignoring the error return value, but
 	// that's on purpose; if you want an error from a goroutine you have to
 	// get it over a channel or something
-	go b.doWork(state, work)
+	go b.doWork(context.Background(), state, errorOutcome(work))
 
 	return nil
 }
@@ -84,24 +365,234 @@ func (b *Breaker) GetState() State {
 	return (State)(atomic.LoadUint32((*uint32)(&b.state)))
 }
 
-func (b *Breaker) doWork(state State, work func() error) error {
-	var panicValue interface{}
+// LastTripError returns the error from the unit of work which most recently caused the breaker to open,
+// or nil if the breaker has never opened. As with WithFailureHandler, panics are reported with an error
+// describing the panic value.
+func (b *Breaker) LastTripError() error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return b.lastTripError
+}
+
+// admit returns the state in which to run a new call, or ErrBreakerOpen if the call should not be run.
+func (b *Breaker) admit(ctx context.Context) (State, error) {
+	state := b.currentState()
+
+	reject := state == Open || (state == HalfOpen && b.probeSelector != nil && !b.probeSelector(ctx))
+	switch {
+	case reject && b.failOpen:
+		// run the call anyway, but as if the breaker were open so that it is not counted
+		return Open, nil
+	case reject:
+		if state == Open {
+			b.openRejected.Add(1)
+		} else {
+			b.halfOpenRejected.Add(1)
+		}
+		return state, b.errOpen()
+	}
+
+	return state, nil
+}
+
+// errOpen returns the error for a call which is not run because the breaker is open.
+func (b *Breaker) errOpen() error {
+	if b.openError == nil {
+		return ErrBreakerOpen
+	}
+	return b.openError()
+}
+
+// Counts returns a snapshot of the breaker's counters at the moment that it is called.
+func (b *Breaker) Counts() Counts {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return Counts{
+		ConsecutiveFailures:  b.errors,
+		ConsecutiveSuccesses: b.successes,
+		OpenRejected:         int(b.openRejected.Load()),
+		HalfOpenRejected:     int(b.halfOpenRejected.Load()),
+	}
+}
+
+// stateChangesBuffer is the number of transitions buffered by the channel returned from StateChanges.
+const stateChangesBuffer = 16
+
+// StateChanges returns a channel which receives the breaker's new state every time it changes, for consumers
+// which prefer a select loop to polling GetState. Every call returns the same channel. Sending never blocks the
+// breaker: the channel buffers the most recent transitions, and if the consumer falls behind then the oldest
+// buffered transitions are dropped to make room, so the last value received is always the current state.
+func (b *Breaker) StateChanges() <-chan State {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.changes == nil {
+		b.changes = make(chan State, stateChangesBuffer)
+	}
+	return b.changes
+}
+
+// Trip forces the breaker open, as if it had seen too many errors; it then half-opens after the usual
+// timeout. Tripping an open breaker does nothing. This is intended for manual intervention by an operator;
+// unlike an automatic trip, it does not change the error returned by LastTripError.
+func (b *Breaker) Trip() {
+	defer b.syncStore()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.state != Open {
+		b.openBreaker()
+	}
+}
+
+// Reset forces the breaker closed, clearing its counters, whatever state it was in. This is intended for
+// manual intervention by an operator.
+func (b *Breaker) Reset() {
+	defer b.syncStore()
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.closeBreaker()
+}
+
+// currentState returns the state to use for a new call, first syncing with the StateStore if
+// one is configured and the last load has expired. Only one caller loads for each expiry.
+func (b *Breaker) currentState() State {
+	if b.store == nil {
+		return b.GetState()
+	}
 
-	result := func() error {
-		defer func() {
-			panicValue = recover()
-		}()
-		return work()
+	now := time.Now().UnixNano()
+	last := b.lastLoad.Load()
+	if now-last < int64(b.storeTTL) || !b.lastLoad.CompareAndSwap(last, now) {
+		return b.GetState()
+	}
+
+	shared, err := b.store.Load()
+	if err != nil {
+		b.storeError(err)
+		return b.GetState()
+	}
+	if shared == Open {
+		defer b.syncStore()
+		b.lock.Lock()
+		defer b.lock.Unlock()
+		if b.state != Open {
+			b.openBreaker()
+		}
+		return b.state
+	}
+	return b.GetState()
+}
+
+// syncStore writes the current state to the StateStore if it has changed since it was last written. It must be
+// called without the lock held.
+func (b *Breaker) syncStore() {
+	if b.store == nil {
+		return
+	}
+
+	b.storeLock.Lock()
+	defer b.storeLock.Unlock()
+
+	b.lock.Lock()
+	state, transitions := b.state, b.transitions
+	b.lock.Unlock()
+
+	if transitions == b.stored {
+		return
+	}
+	b.stored = transitions
+	if err := b.store.Store(state); err != nil {
+		b.storeError(err)
+	}
+}
+
+// storeError reports an error returned by the StateStore.
+func (b *Breaker) storeError(err error) {
+	if b.onStoreError != nil {
+		b.onStoreError(err)
+	}
+}
+
+// errorOutcome adapts a unit of work so that it counts as a failure if and only if it returns an error.
+func errorOutcome(work func() error) func() (Outcome, error) {
+	return func() (Outcome, error) {
+		if err := work(); err != nil {
+			return Failure, err
+		}
+		return Success, nil
+	}
+}
+
+// safely executes a unit of work, recovering any panic (which counts as a failure).
+func safely(work func() (Outcome, error)) (outcome Outcome, result error, panicValue interface{}) {
+	defer func() {
+		if panicValue = recover(); panicValue != nil {
+			outcome = Failure
+		}
 	}()
+	outcome, result = work()
+	return
+}
+
+// execute executes a unit of work, enforcing the hard timeout if one is configured.
+func (b *Breaker) execute(work func() (Outcome, error)) (Outcome, error, interface{}) {
+	if b.hardTimeout <= 0 {
+		return safely(work)
+	}
 
-	if result == nil && panicValue == nil && state == Closed {
+	type ret struct {
+		outcome    Outcome
+		result     error
+		panicValue interface{}
+	}
+	done := make(chan ret, 1)
+	go func() {
+		outcome, result, panicValue := safely(work)
+		done <- ret{outcome, result, panicValue}
+	}()
+
+	timer := time.NewTimer(b.hardTimeout)
+	defer timer.Stop()
+	select {
+	case r := <-done:
+		return r.outcome, r.result, r.panicValue
+	case <-timer.C:
+		return Failure, ErrTimedOut, nil
+	}
+}
+
+func (b *Breaker) doWork(ctx context.Context, state State, work func() (Outcome, error)) error {
+	outcome, result, panicValue := b.execute(work)
+	if state == HalfOpen {
+		for i := 0; i < b.halfOpenRetries && outcome == Failure && panicValue == nil && ctx.Err() == nil; i++ {
+			outcome, result, panicValue = b.execute(work)
+		}
+	}
+
+	if state == Open {
+		// the breaker is failing open, so the call is not counted
+		if panicValue != nil {
+			panic(panicValue)
+		}
+		return result
+	}
+
+	if outcome == Ignore || (outcome == Success && state == Closed && atomic.LoadUint32(&b.failing) == 0) {
 		// short-circuit the normal, success path without contending
 		// on the lock
-		return nil
+		return result
 	}
 
 	// oh well, I guess we have to contend on the lock
-	b.processResult(result, panicValue)
+	failures := b.processResult(ctx, outcome, result, panicValue)
+
+	if failures > 0 && b.onFailure != nil {
+		b.onFailure(failureError(result, panicValue), failures)
+	}
 
 	if panicValue != nil {
 		// as close as Go lets us come to a "rethrow" although unfortunately
@@ -112,59 +603,148 @@ func (b *Breaker) doWork(state State, work func() error) error {
 	return result
 }
 
-func (b *Breaker) processResult(result error, panicValue interface{}) {
+// failureError returns the error describing a failed unit of work.
+func failureError(result error, panicValue interface{}) error {
+	if panicValue != nil {
+		return fmt.Errorf("panic: %v", panicValue)
+	}
+	return result
+}
+
+// processResult records the outcome of a unit of work and returns the number of consecutive
+// failures seen, or 0 if the outcome was not recorded as a failure.
+func (b *Breaker) processResult(ctx context.Context, outcome Outcome, result error, panicValue interface{}) int {
+	defer b.syncStore()
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	if result == nil && panicValue == nil {
-		if b.state == HalfOpen {
+	if outcome == Success {
+		switch b.state {
+		case Closed:
+			// a success breaks any streak of consecutive errors
+			b.resetErrors()
+		case HalfOpen:
 			b.successes++
 			if b.successes == b.successThreshold {
 				b.closeBreaker()
 			}
 		}
-	} else {
-		if b.errors > 0 {
-			expiry := b.lastError.Add(b.timeout)
-			if time.Now().After(expiry) {
-				b.errors = 0
-			}
+		return 0
+	}
+
+	if b.state == HalfOpen && panicValue == nil && ctx.Err() != nil {
+		// the caller abandoned the probe, so it says nothing about the health of the dependency
+		return 0
+	}
+
+	failure := failureError(result, panicValue)
+	immediate := b.immediateTrip != nil && b.immediateTrip(failure)
+
+	weight := 1.0
+	if b.errorWeight != nil && !immediate {
+		if weight = b.errorWeight(failure); weight <= 0 {
+			return 0
+		}
+	}
+
+	if b.errors > 0 {
+		expiry := b.lastError.Add(b.timeout)
+		if time.Now().After(expiry) {
+			b.resetErrors()
 		}
+	}
 
-		switch b.state {
-		case Closed:
-			b.errors++
-			if b.errors == b.errorThreshold {
-				b.openBreaker()
-			} else {
-				b.lastError = time.Now()
-			}
-		case HalfOpen:
+	switch b.state {
+	case Closed:
+		b.errors++
+		b.weight += weight
+		atomic.StoreUint32(&b.failing, 1)
+		failures := b.errors
+		if immediate || b.thresholdReached() {
+			b.lastTripError = failure
 			b.openBreaker()
+		} else {
+			b.lastError = time.Now()
+		}
+		return failures
+	case HalfOpen:
+		b.lastTripError = failure
+		carried := 0
+		if b.halfOpenPenalty > 0 {
+			carried = max(b.successes-b.halfOpenPenalty, 0)
 		}
+		b.openBreaker()
+		b.carried = carried
+		return 1
+	}
+
+	return 0
+}
+
+// thresholdReached reports whether the consecutive errors are enough to trip the breaker. As with the plain count
+// of errors, a weighted sum never reaches an "errorThreshold" of 0 or less.
+func (b *Breaker) thresholdReached() bool {
+	if b.errorWeight == nil {
+		return b.errors == b.errorThreshold
 	}
+	return b.errorThreshold > 0 && b.weight >= float64(b.errorThreshold)
 }
 
 func (b *Breaker) openBreaker() {
 	b.changeState(Open)
-	go b.timer()
+	b.generation++
+	go b.timer(b.generation)
 }
 
 func (b *Breaker) closeBreaker() {
 	b.changeState(Closed)
 }
 
-func (b *Breaker) timer() {
+func (b *Breaker) timer(generation int) {
 	time.Sleep(b.timeout)
 
+	defer b.syncStore()
 	b.lock.Lock()
 	defer b.lock.Unlock()
 
-	b.changeState(HalfOpen)
+	// the breaker may have been reset (and even re-opened) manually in the meantime
+	if b.state == Open && b.generation == generation {
+		carried := b.carried
+		b.changeState(HalfOpen)
+		b.successes = carried
+	}
 }
 
-func (b *Breaker) changeState(newState State) {
+func (b *Breaker) resetErrors() {
 	b.errors = 0
+	b.weight = 0
+	atomic.StoreUint32(&b.failing, 0)
+}
+
+func (b *Breaker) changeState(newState State) {
+	b.resetErrors()
 	b.successes = 0
+	b.carried = 0
+	oldState := b.state
 	atomic.StoreUint32((*uint32)(&b.state), (uint32)(newState))
+	b.transitions++
+	if b.changes != nil && newState != oldState {
+		b.sendStateChange(newState)
+	}
+}
+
+// sendStateChange sends to the StateChanges channel without blocking, dropping the oldest buffered
+// transitions if it is full; the lock must be held.
+func (b *Breaker) sendStateChange(state State) {
+	for {
+		select {
+		case b.changes <- state:
+			return
+		default:
+		}
+		select {
+		case <-b.changes:
+		default:
+		}
+	}
 }
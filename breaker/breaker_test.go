@@ -1,7 +1,11 @@
 package breaker
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -210,6 +214,410 @@ func TestBreakerAsyncStateTransitions(t *testing.T) {
 	}
 }
 
+func TestBreakerSuccessResetsErrors(t *testing.T) {
+	breaker := New(3, 1, 1*time.Second)
+
+	for _, work := range []func() error{returnsError, returnsError, returnsSuccess, returnsError} {
+		_ = breaker.Run(work)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+
+	// the streak is now at one, so two more errors trip it
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+}
+
+func TestBreakerCtx(t *testing.T) {
+	breaker := New(2, 1, 10*time.Millisecond)
+
+	ctx := context.Background()
+	for i := 0; i < 2; i++ {
+		if err := breaker.RunCtx(ctx, func(context.Context) error { return errSomeError }); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+	if err := breaker.RunCtx(ctx, func(context.Context) error { return nil }); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := breaker.RunCtx(ctx, func(context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+}
+
+func TestBreakerCtxCancelledProbe(t *testing.T) {
+	breaker := New(1, 1, 10*time.Millisecond)
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != HalfOpen {
+		t.Error("incorrect state")
+	}
+
+	// a probe abandoned by its caller does not re-open the breaker
+	ctx, cancel := context.WithCancel(context.Background())
+	err := breaker.RunCtx(ctx, func(ctx context.Context) error {
+		cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.Canceled {
+		t.Error(err)
+	}
+	if breaker.GetState() != HalfOpen {
+		t.Error("incorrect state")
+	}
+
+	// and the next successful probe closes it
+	if err := breaker.RunCtx(context.Background(), func(context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+
+	// a probe which fails on its own still re-opens the breaker
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	if err := breaker.RunCtx(context.Background(), func(context.Context) error { return errSomeError }); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+}
+
+func TestBreakerFailureHandler(t *testing.T) {
+	var errs []error
+	var counts []int
+	breaker := New(3, 1, 1*time.Second).WithFailureHandler(func(err error, consecutiveFailures int) {
+		errs = append(errs, err)
+		counts = append(counts, consecutiveFailures)
+	})
+
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if len(counts) != 0 {
+		t.Error("handler called on success")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+
+	if len(counts) != 3 {
+		t.Fatal("handler called wrong number of times")
+	}
+	for i := range counts {
+		if counts[i] != i+1 {
+			t.Error("incorrect consecutive failure count", counts[i])
+		}
+		if errs[i] != errSomeError {
+			t.Error("incorrect error", errs[i])
+		}
+	}
+
+	// rejected calls are not recorded as failures
+	if err := breaker.Run(returnsError); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if len(counts) != 3 {
+		t.Error("handler called for rejected call")
+	}
+}
+
+func TestBreakerLastTripError(t *testing.T) {
+	breaker := New(2, 1, 10*time.Millisecond)
+	if breaker.LastTripError() != nil {
+		t.Error("breaker has not tripped")
+	}
+
+	errFirst := errors.New("first")
+	if err := breaker.Run(func() error { return errFirst }); err != errFirst {
+		t.Error(err)
+	}
+	if breaker.LastTripError() != nil {
+		t.Error("breaker has not tripped")
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+	if breaker.LastTripError() != errSomeError {
+		t.Error("incorrect trip error", breaker.LastTripError())
+	}
+
+	// a failed probe re-trips it
+	time.Sleep(20 * time.Millisecond)
+	if err := breaker.Run(func() error { return errFirst }); err != errFirst {
+		t.Error(err)
+	}
+	if breaker.LastTripError() != errFirst {
+		t.Error("incorrect trip error", breaker.LastTripError())
+	}
+
+	// and closing does not clear it
+	time.Sleep(20 * time.Millisecond)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+	if breaker.LastTripError() != errFirst {
+		t.Error("incorrect trip error", breaker.LastTripError())
+	}
+}
+
+func TestBreakerRunWithOutcome(t *testing.T) {
+	breaker := New(2, 1, 10*time.Millisecond)
+
+	// errors reported as successes or ignored don't count
+	for i := 0; i < 5; i++ {
+		if err := breaker.RunWithOutcome(func() (Outcome, error) { return Success, errSomeError }); err != errSomeError {
+			t.Error(err)
+		}
+		if err := breaker.RunWithOutcome(func() (Outcome, error) { return Ignore, errSomeError }); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+
+	// nil errors reported as failures do
+	for i := 0; i < 2; i++ {
+		if err := breaker.RunWithOutcome(func() (Outcome, error) { return Failure, nil }); err != nil {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("incorrect state")
+	}
+	if err := breaker.RunWithOutcome(func() (Outcome, error) { return Success, nil }); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	// ignored probes leave the breaker half-open
+	time.Sleep(20 * time.Millisecond)
+	if err := breaker.RunWithOutcome(func() (Outcome, error) { return Ignore, errSomeError }); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != HalfOpen {
+		t.Error("incorrect state")
+	}
+	if err := breaker.RunWithOutcome(func() (Outcome, error) { return Success, errSomeError }); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+}
+
+func TestBreakerHardTimeout(t *testing.T) {
+	failures := 0
+	var lastErr error
+	breaker := New(2, 1, 1*time.Second).WithHardTimeout(10 * time.Millisecond).
+		WithFailureHandler(func(err error, consecutiveFailures int) {
+			failures = consecutiveFailures
+			lastErr = err
+		})
+
+	release := make(chan struct{})
+	defer close(release)
+	slow := func() error {
+		<-release
+		return nil
+	}
+
+	start := time.Now()
+	if err := breaker.Run(slow); err != ErrTimedOut {
+		t.Error(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("did not time out")
+	}
+	if failures != 1 || lastErr != ErrTimedOut || breaker.GetState() != Closed {
+		t.Error("incorrect failure recorded", failures, lastErr)
+	}
+
+	// fast work is unaffected
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(returnsSuccess); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// and panics still propagate
+	func() {
+		defer func() {
+			if val := recover(); val != "foo" {
+				t.Error("incorrect panic", val)
+			}
+		}()
+		_ = breaker.Run(alwaysPanics)
+		t.Error("shouldn't get here")
+	}()
+
+	if err := breaker.Run(slow); err != ErrTimedOut {
+		t.Error(err)
+	}
+	if failures != 2 || lastErr != ErrTimedOut || breaker.GetState() != Open {
+		t.Error("incorrect failure recorded", failures, lastErr)
+	}
+}
+
+type memoryStore struct {
+	lock  sync.Mutex
+	state State
+}
+
+func (s *memoryStore) Load() (State, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.state, nil
+}
+
+func (s *memoryStore) Store(state State) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.state = state
+	return nil
+}
+
+func TestBreakerStateStore(t *testing.T) {
+	store := &memoryStore{}
+	first := New(2, 1, 10*time.Millisecond).WithStateStore(store, 0)
+	second := New(2, 1, 10*time.Millisecond).WithStateStore(store, 0)
+
+	if err := second.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := first.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if first.GetState() != Open {
+		t.Error("incorrect state")
+	}
+	if state, _ := store.Load(); state != Open {
+		t.Error("state not stored")
+	}
+
+	// the second breaker observes the trip without seeing any errors itself
+	if err := second.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if second.GetState() != Open {
+		t.Error("incorrect state")
+	}
+
+	// both half-close after the timeout and close again on success
+	time.Sleep(20 * time.Millisecond)
+	if err := first.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if err := second.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if first.GetState() != Closed || second.GetState() != Closed {
+		t.Error("incorrect state")
+	}
+}
+
+func TestBreakerStateStoreTTL(t *testing.T) {
+	store := &memoryStore{}
+	breaker := New(2, 1, 10*time.Millisecond).WithStateStore(store, 1*time.Second)
+
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+
+	// the shared state is not consulted again until the ttl expires
+	_ = store.Store(Open)
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+}
+
+// blockingStore is a StateStore whose Store blocks until released, and whose Load always fails.
+type blockingStore struct {
+	storing chan State
+	release chan struct{}
+}
+
+func (s *blockingStore) Load() (State, error) {
+	return Closed, errSomeError
+}
+
+func (s *blockingStore) Store(state State) error {
+	s.storing <- state
+	<-s.release
+	return errSomeError
+}
+
+func TestBreakerStateStoreOutsideLock(t *testing.T) {
+	store := &blockingStore{storing: make(chan State, 1), release: make(chan struct{})}
+	var storeErrors atomic.Int32
+	breaker := New(1, 1, time.Hour).WithStateStore(store, time.Hour).WithStoreErrorHandler(func(err error) {
+		if err == errSomeError {
+			storeErrors.Add(1)
+		}
+	})
+
+	done := make(chan error)
+	go func() { done <- breaker.Run(returnsError) }()
+	if state := <-store.storing; state != Open {
+		t.Error("wrong state stored", state)
+	}
+
+	// while the store is busy, the breaker itself is not locked
+	if breaker.GetState() != Open || breaker.Counts().ConsecutiveFailures != 0 || breaker.LastTripError() != errSomeError {
+		t.Error("wrong state while storing")
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	close(store.release)
+	if err := <-done; err != errSomeError {
+		t.Error(err)
+	}
+	// one error from loading before the first call, and one from storing
+	if n := storeErrors.Load(); n != 2 {
+		t.Error("store errors not reported", n)
+	}
+}
+
 func ExampleBreaker() {
 	breaker := New(3, 1, 5*time.Second)
 
@@ -230,3 +638,528 @@ func ExampleBreaker() {
 		}
 	}
 }
+
+type probeKey struct{}
+
+func TestBreakerProbeSelector(t *testing.T) {
+	isProbe := func(ctx context.Context) bool {
+		return ctx.Value(probeKey{}) != nil
+	}
+	probe := context.WithValue(context.Background(), probeKey{}, true)
+	succeeds := func(context.Context) error { return nil }
+
+	breaker := New(1, 2, 10*time.Millisecond).WithProbeSelector(isProbe)
+
+	// the selector is ignored while closed
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if err := breaker.RunCtx(probe, succeeds); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != HalfOpen {
+		t.Fatal("breaker did not half-open")
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+			t.Error(err)
+		}
+		if err := breaker.RunCtx(context.Background(), succeeds); err != ErrBreakerOpen {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != HalfOpen {
+		t.Error("unselected calls changed the state")
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := breaker.RunCtx(probe, succeeds); err != nil {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed {
+		t.Error("selected probes did not close the breaker")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBreakerErrorWeights(t *testing.T) {
+	errRefused := errors.New("connection refused")
+	errSlow := errors.New("timeout")
+	weights := func(err error) float64 {
+		switch err {
+		case errRefused:
+			return 2
+		case errSlow:
+			return 0.5
+		}
+		return 0
+	}
+	fails := func(err error) func() error {
+		return func() error { return err }
+	}
+
+	breaker := New(4, 1, 1*time.Second).WithErrorWeights(weights)
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(fails(errRefused)); err != errRefused {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("heavy errors did not trip the breaker")
+	}
+
+	breaker = New(4, 1, 1*time.Second).WithErrorWeights(weights)
+	for i := 0; i < 7; i++ {
+		if err := breaker.Run(fails(errSlow)); err != errSlow {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed {
+		t.Error("light errors tripped the breaker too soon")
+	}
+	// errors weighted 0 are ignored
+	for i := 0; i < 10; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed {
+		t.Error("zero-weight errors tripped the breaker")
+	}
+	if err := breaker.Run(fails(errSlow)); err != errSlow {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("light errors did not trip the breaker")
+	}
+
+	// a threshold of 0 never trips, with or without weights
+	for _, breaker := range []*Breaker{New(0, 1, time.Second), New(0, 1, time.Second).WithErrorWeights(weights)} {
+		for i := 0; i < 5; i++ {
+			if err := breaker.Run(fails(errRefused)); err != errRefused {
+				t.Error(err)
+			}
+		}
+		if breaker.GetState() != Closed {
+			t.Error("breaker with no threshold tripped")
+		}
+	}
+}
+
+func TestBreakerRunWithClassifier(t *testing.T) {
+	errNotFound := errors.New("not found")
+	benign := func(err error) bool { return err != errNotFound }
+	failing := func(err error) bool { return true }
+	notFound := func() error { return errNotFound }
+
+	breaker := New(2, 1, 1*time.Second)
+	for i := 0; i < 3; i++ {
+		if err := breaker.RunWithClassifier(notFound, benign); err != errNotFound {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed || breaker.Counts().ConsecutiveFailures != 0 {
+		t.Error("benign error counted as a failure", breaker.Counts())
+	}
+
+	if err := breaker.RunWithClassifier(notFound, failing); err != errNotFound {
+		t.Error(err)
+	}
+	if breaker.Counts().ConsecutiveFailures != 1 {
+		t.Error("failing error not counted", breaker.Counts())
+	}
+	// a benign error is a success, which breaks the streak
+	if err := breaker.RunWithClassifier(notFound, benign); err != errNotFound {
+		t.Error(err)
+	}
+	if breaker.Counts().ConsecutiveFailures != 0 {
+		t.Error("benign error did not count as a success", breaker.Counts())
+	}
+
+	for i := 0; i < 2; i++ {
+		if err := breaker.RunWithClassifier(notFound, failing); err != errNotFound {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("failing errors did not trip the breaker")
+	}
+
+	// the breaker's default classification is unaffected
+	breaker = New(1, 1, 1*time.Second)
+	if err := breaker.Run(notFound); err != errNotFound {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("default classification changed")
+	}
+}
+
+func TestBreakerImmediateTripOn(t *testing.T) {
+	errRevoked := errors.New("credentials revoked")
+	breaker := New(3, 1, 1*time.Second).WithImmediateTripOn(func(err error) bool {
+		return errors.Is(err, errRevoked)
+	})
+
+	for i := 0; i < 2; i++ {
+		if err := breaker.Run(returnsError); err != errSomeError {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Closed {
+		t.Error("ordinary failures tripped the breaker before the threshold")
+	}
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("ordinary failures did not trip the breaker at the threshold")
+	}
+
+	breaker.Reset()
+	err := breaker.Run(func() error { return fmt.Errorf("calling service: %w", errRevoked) })
+	if !errors.Is(err, errRevoked) {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Error("catastrophic error did not trip the breaker immediately")
+	}
+	if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+}
+
+func TestBreakerHalfOpenFailurePenalty(t *testing.T) {
+	breaker := New(1, 4, 10*time.Millisecond).WithHalfOpenFailurePenalty(1)
+
+	breaker.Run(returnsError)
+	time.Sleep(20 * time.Millisecond)
+	for i := 0; i < 3; i++ {
+		breaker.Run(returnsSuccess)
+	}
+	breaker.Run(returnsError)
+	if breaker.GetState() != Open {
+		t.Fatal("failed probe did not re-open the breaker")
+	}
+
+	// two of the three successes are kept, so two more are needed to close
+	time.Sleep(20 * time.Millisecond)
+	if counts := breaker.Counts(); breaker.GetState() != HalfOpen || counts.ConsecutiveSuccesses != 2 {
+		t.Fatal("successes not retained", breaker.GetState(), counts)
+	}
+	breaker.Run(returnsSuccess)
+	if breaker.GetState() != HalfOpen {
+		t.Error("breaker closed before reaching the threshold")
+	}
+	breaker.Run(returnsSuccess)
+	if breaker.GetState() != Closed {
+		t.Error("breaker did not close at the threshold")
+	}
+
+	// by default a failed probe discards all the successes
+	breaker = New(1, 4, 10*time.Millisecond)
+	breaker.Run(returnsError)
+	time.Sleep(20 * time.Millisecond)
+	breaker.Run(returnsSuccess)
+	breaker.Run(returnsError)
+	time.Sleep(20 * time.Millisecond)
+	if counts := breaker.Counts(); breaker.GetState() != HalfOpen || counts.ConsecutiveSuccesses != 0 {
+		t.Error("successes retained by default", breaker.GetState(), counts)
+	}
+}
+
+func TestBreakerHalfOpenRetries(t *testing.T) {
+	breaker := New(1, 1, 10*time.Millisecond).WithHalfOpenRetries(2)
+
+	breaker.Run(returnsError)
+	time.Sleep(20 * time.Millisecond)
+	calls := 0
+	err := breaker.Run(func() error {
+		calls++
+		if calls < 3 {
+			return errSomeError
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Error("probe not retried", err, calls)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("successful retry did not close the breaker")
+	}
+
+	breaker.Run(returnsError)
+	time.Sleep(20 * time.Millisecond)
+	calls = 0
+	err = breaker.Run(func() error {
+		calls++
+		return errSomeError
+	})
+	if err != errSomeError || calls != 3 {
+		t.Error("probe retried wrong number of times", err, calls)
+	}
+	if breaker.GetState() != Open {
+		t.Error("failed retries did not re-open the breaker")
+	}
+
+	// calls while closed are never retried
+	breaker = New(2, 1, 10*time.Millisecond).WithHalfOpenRetries(2)
+	calls = 0
+	breaker.Run(func() error {
+		calls++
+		return errSomeError
+	})
+	if calls != 1 {
+		t.Error("closed call retried", calls)
+	}
+}
+
+func TestBreakerOpenError(t *testing.T) {
+	breaker := New(1, 1, 1*time.Second).WithOpenError(func() error {
+		return fmt.Errorf("payments service: %w", ErrBreakerOpen)
+	})
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+
+	err := breaker.Run(returnsSuccess)
+	if !errors.Is(err, ErrBreakerOpen) {
+		t.Error(err)
+	}
+	if err.Error() != "payments service: circuit breaker is open" {
+		t.Error(err)
+	}
+	if err := breaker.Go(returnsSuccess); !errors.Is(err, ErrBreakerOpen) || err == ErrBreakerOpen {
+		t.Error(err)
+	}
+}
+
+func TestBreakerFailOpen(t *testing.T) {
+	breaker := New(1, 1, 10*time.Millisecond).WithFailOpen()
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if breaker.GetState() != Open {
+		t.Fatal("breaker did not open")
+	}
+
+	// calls still run while open, but are not counted
+	calls := 0
+	for i := 0; i < 3; i++ {
+		err := breaker.Run(func() error {
+			calls++
+			return nil
+		})
+		if err != nil {
+			t.Error(err)
+		}
+	}
+	if calls != 3 {
+		t.Error("work not run while open", calls)
+	}
+	if breaker.GetState() != Open {
+		t.Error("uncounted calls changed the state")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != HalfOpen {
+		t.Fatal("breaker did not half-open")
+	}
+	if err := breaker.Run(returnsSuccess); err != nil {
+		t.Error(err)
+	}
+	if breaker.GetState() != Closed {
+		t.Error("probe was not counted")
+	}
+}
+
+func TestBreakerTripReset(t *testing.T) {
+	breaker := New(3, 1, 10*time.Millisecond)
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+	if counts := breaker.Counts(); counts.ConsecutiveFailures != 1 {
+		t.Error("wrong counts", counts)
+	}
+
+	breaker.Trip()
+	if breaker.GetState() != Open {
+		t.Error("breaker not tripped")
+	}
+	if breaker.LastTripError() != nil {
+		t.Error("manual trip changed the last trip error")
+	}
+
+	// a reset breaker stays closed even once the original timeout passes
+	breaker.Reset()
+	if breaker.GetState() != Closed {
+		t.Error("breaker not reset")
+	}
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != Closed {
+		t.Error("stale timer changed the state")
+	}
+
+	// a tripped breaker half-opens after the timeout
+	breaker.Trip()
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != HalfOpen {
+		t.Error("tripped breaker did not half-open")
+	}
+}
+
+func TestBreakerStateChanges(t *testing.T) {
+	breaker := New(1, 1, 10*time.Millisecond)
+	changes := breaker.StateChanges()
+	if breaker.StateChanges() != changes {
+		t.Error("different channels returned")
+	}
+
+	breaker.Run(returnsError)
+	if state := <-changes; state != Open {
+		t.Error("wrong state", state)
+	}
+	if state := <-changes; state != HalfOpen {
+		t.Error("wrong state", state)
+	}
+	breaker.Run(returnsSuccess)
+	if state := <-changes; state != Closed {
+		t.Error("wrong state", state)
+	}
+
+	// resetting a closed breaker is not a transition
+	breaker.Reset()
+	select {
+	case state := <-changes:
+		t.Error("unexpected transition", state)
+	default:
+	}
+}
+
+func TestBreakerStateChangesOverflow(t *testing.T) {
+	breaker := New(1, 1, time.Hour)
+	changes := breaker.StateChanges()
+
+	// nobody is reading, so the oldest transitions are dropped rather than blocking the breaker
+	for i := 0; i < stateChangesBuffer; i++ {
+		breaker.Trip()
+		breaker.Reset()
+	}
+	breaker.Trip()
+
+	if len(changes) != stateChangesBuffer {
+		t.Fatal("wrong number of buffered transitions", len(changes))
+	}
+	for i := 0; i < stateChangesBuffer; i++ {
+		expected := Closed
+		if i%2 == 1 {
+			expected = Open
+		}
+		if state := <-changes; state != expected {
+			t.Error("wrong state", i, state)
+		}
+	}
+}
+
+func TestBreakerRejectionCounts(t *testing.T) {
+	probe := context.WithValue(context.Background(), probeKey{}, true)
+	breaker := New(1, 1, 10*time.Millisecond).WithProbeSelector(func(ctx context.Context) bool {
+		return ctx.Value(probeKey{}) != nil
+	})
+
+	if err := breaker.Run(returnsError); err != errSomeError {
+		t.Error(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	time.Sleep(20 * time.Millisecond)
+	if breaker.GetState() != HalfOpen {
+		t.Fatal("breaker did not half-open")
+	}
+	for i := 0; i < 3; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := breaker.Run(returnsSuccess); err != ErrBreakerOpen {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	counts := breaker.Counts()
+	if counts.OpenRejected != 5 || counts.HalfOpenRejected != 3 {
+		t.Error("wrong rejection counts", counts)
+	}
+
+	// the counters are cumulative, and survive the breaker closing
+	if err := breaker.RunCtx(probe, func(context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+	if counts := breaker.Counts(); counts.OpenRejected != 5 || counts.HalfOpenRejected != 3 {
+		t.Error("wrong rejection counts", counts)
+	}
+}
+
+func TestBreakerRunStreaming(t *testing.T) {
+	breaker := New(2, 1, 1*time.Second).WithSlowCallThreshold(20 * time.Millisecond)
+
+	// a stream which keeps heartbeating is healthy, however long it runs
+	err := breaker.RunStreaming(context.Background(), func(ctx context.Context, heartbeat func()) error {
+		for i := 0; i < 10; i++ {
+			time.Sleep(5 * time.Millisecond)
+			heartbeat()
+		}
+		return ctx.Err()
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if counts := breaker.Counts(); counts.ConsecutiveFailures != 0 {
+		t.Error("healthy stream counted as a failure")
+	}
+
+	// a stream which stops heartbeating is cancelled and counted as a failure
+	stalls := func(ctx context.Context, heartbeat func()) error {
+		heartbeat()
+		<-ctx.Done()
+		if context.Cause(ctx) != ErrStreamStalled {
+			t.Error("wrong cause", context.Cause(ctx))
+		}
+		return ctx.Err()
+	}
+	for i := 0; i < 2; i++ {
+		if err := breaker.RunStreaming(context.Background(), stalls); err != ErrStreamStalled {
+			t.Error(err)
+		}
+	}
+	if breaker.GetState() != Open {
+		t.Error("stalled streams did not trip the breaker")
+	}
+	if err := breaker.RunStreaming(context.Background(), stalls); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+}
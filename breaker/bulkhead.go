@@ -0,0 +1,36 @@
+package breaker
+
+import (
+	"context"
+
+	"github.com/eapache/go-resiliency/semaphore"
+)
+
+// Bulkhead combines a semaphore and a circuit-breaker, limiting the number of concurrent calls to a
+// dependency as well as cutting it off entirely when it is failing.
+type Bulkhead struct {
+	sem     *semaphore.Semaphore
+	breaker *Breaker
+}
+
+// NewBulkhead constructs a new Bulkhead from the given semaphore and breaker, either of which may also be
+// used directly.
+func NewBulkhead(sem *semaphore.Semaphore, breaker *Breaker) *Bulkhead {
+	return &Bulkhead{
+		sem:     sem,
+		breaker: breaker,
+	}
+}
+
+// Run acquires a ticket from the semaphore and then runs the given function through the breaker (as with
+// the breaker's RunCtx), releasing the ticket when it is done. If no ticket can be acquired, the function is
+// not run and the semaphore's error (ErrAcquireTimeout, or the context's error) is returned; this is not counted
+// by the breaker. It is safe to call Run concurrently on the same Bulkhead.
+func (b *Bulkhead) Run(ctx context.Context, work func(context.Context) error) error {
+	if err := b.sem.AcquireCtx(ctx); err != nil {
+		return err
+	}
+	defer b.sem.Release()
+
+	return b.breaker.RunCtx(ctx, work)
+}
@@ -0,0 +1,73 @@
+package breaker
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/semaphore"
+)
+
+func succeeds(context.Context) error { return nil }
+
+func TestBulkheadBoundsConcurrency(t *testing.T) {
+	b := NewBulkhead(semaphore.New(2, 10*time.Millisecond), New(3, 1, 1*time.Second))
+
+	release := make(chan struct{})
+	var running, maxRunning int32
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := b.Run(context.Background(), func(context.Context) error {
+				n := atomic.AddInt32(&running, 1)
+				if n > atomic.LoadInt32(&maxRunning) {
+					atomic.StoreInt32(&maxRunning, n)
+				}
+				<-release
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if err := b.Run(context.Background(), succeeds); err != semaphore.ErrNoTickets {
+		t.Error(err)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if maxRunning != 2 {
+		t.Error("incorrect concurrency", maxRunning)
+	}
+	if err := b.Run(context.Background(), succeeds); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestBulkheadTripsBreaker(t *testing.T) {
+	sem := semaphore.New(1, 10*time.Millisecond)
+	b := NewBulkhead(sem, New(2, 1, 1*time.Second))
+
+	failing := func(context.Context) error { return errSomeError }
+	for i := 0; i < 2; i++ {
+		if err := b.Run(context.Background(), failing); err != errSomeError {
+			t.Error(err)
+		}
+	}
+
+	if err := b.Run(context.Background(), succeeds); err != ErrBreakerOpen {
+		t.Error(err)
+	}
+	if !sem.IsEmpty() {
+		t.Error("bulkhead did not release its ticket")
+	}
+}
@@ -2,16 +2,38 @@
 package deadline
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"time"
 )
 
-// ErrTimedOut is the error returned from Run when the deadline expires.
+// ErrTimedOut is the error returned from Run when the deadline expires. The error actually returned is a
+// *TimeoutError, so it should be compared using errors.Is.
 var ErrTimedOut = errors.New("timed out waiting for function to finish")
 
+// TimeoutError is the error returned from Run (and RunCtx and RunWithPartial) when the deadline expires. It
+// matches ErrTimedOut when compared using errors.Is.
+type TimeoutError struct {
+	Timeout time.Duration // the timeout of the Deadline
+	Elapsed time.Duration // the time since the work function was started, including any grace period
+}
+
+func (e *TimeoutError) Error() string {
+	return fmt.Sprintf("%s after %v", ErrTimedOut, e.Timeout)
+}
+
+// Is reports whether target is ErrTimedOut, so that errors.Is(err, ErrTimedOut) works.
+func (e *TimeoutError) Is(target error) bool {
+	return target == ErrTimedOut
+}
+
 // Deadline implements the deadline/timeout resiliency pattern.
 type Deadline struct {
-	timeout time.Duration
+	timeout  time.Duration
+	cause    error
+	grace    time.Duration
+	observer func(timedOut bool, elapsed time.Duration)
 }
 
 // New constructs a new Deadline with the given timeout.
@@ -21,6 +43,55 @@ func New(timeout time.Duration) *Deadline {
 	}
 }
 
+// NewWithCause constructs a new Deadline with the given timeout. When the deadline expires during RunCtx,
+// context.Cause on the context passed to the work function returns the given cause, so the work function
+// can tell this deadline apart from other reasons for cancellation.
+func NewWithCause(timeout time.Duration, cause error) *Deadline {
+	return &Deadline{
+		timeout: timeout,
+		cause:   cause,
+	}
+}
+
+// NewWithGrace constructs a new Deadline with the given timeout and grace period. When the timeout passes,
+// the work function is signalled to stop as usual, but Run (or RunCtx) then waits up to "grace" for it to
+// return cleanly. If it does, its return value is returned from Run; if not, Run gives up and returns
+// ErrTimedOut.
+func NewWithGrace(timeout, grace time.Duration) *Deadline {
+	return &Deadline{
+		timeout: timeout,
+		grace:   grace,
+	}
+}
+
+// WithObserver configures the deadline to call the given function after each call to Run or RunCtx, for
+// tracking how often work breaches its deadline. If the timeout passed before the work finished, it is called
+// with true and the timeout (even if the work then finished within a grace period); otherwise it is called
+// with false and how long the work took. When RunCtx returns because its parent context was done, that is not
+// counted as a timeout. The function must be safe to call concurrently.
+func (d *Deadline) WithObserver(fn func(timedOut bool, elapsed time.Duration)) *Deadline {
+	d.observer = fn
+	return d
+}
+
+// Tightest returns a Deadline whose timeout is the smallest of the given deadlines' timeouts, for stacking e.g.
+// a service-level deadline with a request-level one. The result is a copy of the deadline with the smallest
+// timeout (the first, in case of a tie), so it also has that deadline's cause and grace period. It panics if no
+// deadlines are given.
+func Tightest(ds ...*Deadline) *Deadline {
+	if len(ds) == 0 {
+		panic("deadline: Tightest called with no deadlines")
+	}
+	tightest := ds[0]
+	for _, d := range ds[1:] {
+		if d.timeout < tightest.timeout {
+			tightest = d
+		}
+	}
+	combined := *tightest
+	return &combined
+}
+
 // Run runs the given function, passing it a stopper channel. If the deadline passes before
 // the function finishes executing, Run returns ErrTimeOut to the caller and closes the stopper
 // channel so that the work function can attempt to exit gracefully. It does not (and cannot)
@@ -28,6 +99,7 @@ func New(timeout time.Duration) *Deadline {
 // then it may keep running after the deadline passes. If the function finishes before the
 // deadline, then the return value of the function is returned from Run.
 func (d *Deadline) Run(work func(<-chan struct{}) error) error {
+	start := time.Now()
 	result := make(chan error, 1)
 	stopper := make(chan struct{})
 
@@ -36,12 +108,144 @@ func (d *Deadline) Run(work func(<-chan struct{}) error) error {
 	}()
 
 	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
 	select {
 	case ret := <-result:
-		timer.Stop()
+		d.observe(false, time.Since(start))
 		return ret
 	case <-timer.C:
 		close(stopper)
-		return ErrTimedOut
+		d.observe(true, d.timeout)
+		return d.awaitGrace(start, result)
+	}
+}
+
+// RunCtx runs the given function, passing it a context derived from ctx which is cancelled when the deadline
+// passes. If the deadline passes before the function finishes executing, RunCtx returns ErrTimedOut to the
+// caller, even if the function has already returned in response to the cancellation. If ctx itself is done
+// first (e.g. because its own deadline is sooner than the timeout), then the effective timeout is the sooner of
+// the two, and RunCtx instead returns ctx.Err(). As with Run, it does not (and cannot) kill the running
+// function's goroutine, so the function should respect the cancellation of its context. If the Deadline was
+// constructed with NewWithCause, then context.Cause on the function's context returns that cause once the
+// deadline passes. If the function finishes before the deadline, then the return value of the function is
+// returned from RunCtx.
+func (d *Deadline) RunCtx(ctx context.Context, work func(context.Context) error) error {
+	start := time.Now()
+	parent := ctx
+	ctx, cancel := context.WithTimeoutCause(parent, d.timeout, d.cause)
+	defer cancel()
+
+	result := make(chan error, 1)
+
+	go func() {
+		result <- work(ctx)
+	}()
+
+	select {
+	case ret := <-result:
+		if ctx.Err() == nil {
+			d.observe(false, time.Since(start))
+			return ret
+		}
+		// the work returned because its context was done, and select happened to pick this case; put the
+		// result back and handle it exactly as if the context had been seen first
+		result <- ret
+		return d.ctxDone(parent, start, result)
+	case <-ctx.Done():
+		return d.ctxDone(parent, start, result)
+	}
+}
+
+// ctxDone handles the context passed to the work function by RunCtx being done, either because the parent
+// context was done or because the deadline passed.
+func (d *Deadline) ctxDone(parent context.Context, start time.Time, result <-chan error) error {
+	if err := parent.Err(); err != nil {
+		d.observe(false, time.Since(start))
+		return err
+	}
+	d.observe(true, d.timeout)
+	return d.awaitGrace(start, result)
+}
+
+// observe calls the observer, if there is one.
+func (d *Deadline) observe(timedOut bool, elapsed time.Duration) {
+	if d.observer != nil {
+		d.observer(timedOut, elapsed)
+	}
+}
+
+// awaitGrace waits up to the grace period for a work function which has been told to stop to return.
+func (d *Deadline) awaitGrace(start time.Time, result <-chan error) error {
+	if d.grace <= 0 {
+		return d.timedOut(start)
+	}
+
+	timer := time.NewTimer(d.grace)
+	defer timer.Stop()
+	select {
+	case ret := <-result:
+		return ret
+	case <-timer.C:
+		return d.timedOut(start)
+	}
+}
+
+// timedOut returns the error for a work function started at the given time which did not finish in time.
+func (d *Deadline) timedOut(start time.Time) error {
+	return &TimeoutError{
+		Timeout: d.timeout,
+		Elapsed: time.Since(start),
+	}
+}
+
+// RunWithPartial is like Run, but the work function is also passed a channel on which it can send partial
+// results as it produces them; every value sent before Run returns is collected and returned, alongside
+// either the work function's return value or ErrTimedOut. Values sent after the deadline (and any grace
+// period) has passed are discarded, so sending never blocks the work function indefinitely, and the
+// returned slice is never modified once RunWithPartial has returned. The work function must not close
+// the channel.
+func RunWithPartial[T any](d *Deadline, work func(stopper <-chan struct{}, partial chan<- T) error) ([]T, error) {
+	start := time.Now()
+	values := make(chan T)
+	result := make(chan error, 1)
+	stopper := make(chan struct{})
+
+	go func() {
+		result <- work(stopper, values)
+	}()
+
+	var partial []T
+	stopped := false
+	timer := time.NewTimer(d.timeout)
+	defer timer.Stop()
+	for {
+		select {
+		case v := <-values:
+			partial = append(partial, v)
+		case ret := <-result:
+			return partial, ret
+		case <-timer.C:
+			if !stopped {
+				stopped = true
+				close(stopper)
+				if d.grace > 0 {
+					timer.Reset(d.grace)
+					continue
+				}
+			}
+			go discard(values, result)
+			return partial, d.timedOut(start)
+		}
+	}
+}
+
+// discard drops any further values sent by an abandoned work function until it returns.
+func discard[T any](values <-chan T, result <-chan error) {
+	for {
+		select {
+		case <-values:
+		case <-result:
+			return
+		}
 	}
 }
@@ -1,7 +1,11 @@
 package deadline
 
 import (
+	"context"
 	"errors"
+	"runtime"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -27,7 +31,7 @@ func TestDeadline(t *testing.T) {
 		t.Error(err)
 	}
 
-	if err := dl.Run(takesTwentyMillis); err != ErrTimedOut {
+	if err := dl.Run(takesTwentyMillis); !errors.Is(err, ErrTimedOut) {
 		t.Error(err)
 	}
 
@@ -41,12 +45,367 @@ func TestDeadline(t *testing.T) {
 		close(done)
 		return nil
 	})
-	if err != ErrTimedOut {
+	if !errors.Is(err, ErrTimedOut) {
 		t.Error(err)
 	}
 	<-done
 }
 
+func TestDeadlineCtx(t *testing.T) {
+	dl := New(10 * time.Millisecond)
+
+	if err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		return takesFiveMillis(ctx.Done())
+	}); err != nil {
+		t.Error(err)
+	}
+
+	if err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		return takesTwentyMillis(ctx.Done())
+	}); !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+
+	if err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		return returnsError(ctx.Done())
+	}); err.Error() != "foo" {
+		t.Error(err)
+	}
+
+	done := make(chan struct{})
+	err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		close(done)
+		return nil
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	<-done
+}
+
+func TestDeadlineCtxCooperative(t *testing.T) {
+	var timedOut atomic.Int64
+	dl := New(time.Millisecond).WithObserver(func(breached bool, _ time.Duration) {
+		if breached {
+			timedOut.Add(1)
+		}
+	})
+	graceful := NewWithGrace(time.Millisecond, time.Second)
+	cooperative := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// work which returns as soon as its context is done races the deadline inside RunCtx, so run plenty of it
+	// concurrently; it must always be reported as timed out
+	var wg sync.WaitGroup
+	errs := make(chan error, 20*50*2)
+	for g := 0; g < 20; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				if err := dl.RunCtx(context.Background(), cooperative); !errors.Is(err, ErrTimedOut) {
+					errs <- err
+				}
+				// with a grace period, the work's own return value is used
+				if err := graceful.RunCtx(context.Background(), cooperative); err != context.DeadlineExceeded {
+					errs <- err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if n := timedOut.Load(); n != 20*50 {
+		t.Error("timeouts not observed", n)
+	}
+
+	// and a parent context which is done first still wins
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	if err := New(time.Second).RunCtx(ctx, cooperative); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
+
+func TestDeadlineCtxCause(t *testing.T) {
+	errCause := errors.New("database query deadline")
+	dl := NewWithCause(10*time.Millisecond, errCause)
+
+	causes := make(chan error, 1)
+	err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if cause := <-causes; cause != errCause {
+		t.Error("incorrect cause", cause)
+	}
+
+	// without a cause, the standard context error is used
+	dl = New(10 * time.Millisecond)
+	err = dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		causes <- context.Cause(ctx)
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if cause := <-causes; cause != context.DeadlineExceeded {
+		t.Error("incorrect cause", cause)
+	}
+}
+
+func TestDeadlineCtxParent(t *testing.T) {
+	blocks := func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// parent deadline is shorter
+	dl := New(1 * time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	if err := dl.RunCtx(ctx, blocks); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("parent deadline not honoured")
+	}
+
+	// own deadline is shorter
+	dl = New(10 * time.Millisecond)
+	ctx, cancel = context.WithTimeout(context.Background(), 1*time.Second)
+	defer cancel()
+	start = time.Now()
+	if err := dl.RunCtx(ctx, blocks); !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if time.Since(start) > 500*time.Millisecond {
+		t.Error("deadline not honoured")
+	}
+
+	// no parent deadline
+	start = time.Now()
+	if err := dl.RunCtx(context.Background(), blocks); !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed < 10*time.Millisecond || elapsed > 500*time.Millisecond {
+		t.Error("deadline not honoured")
+	}
+
+	// parent cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := dl.RunCtx(ctx, blocks); err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestDeadlineGrace(t *testing.T) {
+	dl := NewWithGrace(10*time.Millisecond, 50*time.Millisecond)
+	errCleanedUp := errors.New("cleaned up")
+
+	// work which cleans up within the grace period
+	err := dl.Run(func(stopper <-chan struct{}) error {
+		<-stopper
+		time.Sleep(5 * time.Millisecond)
+		return errCleanedUp
+	})
+	if err != errCleanedUp {
+		t.Error(err)
+	}
+
+	err = dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		time.Sleep(5 * time.Millisecond)
+		return errCleanedUp
+	})
+	if err != errCleanedUp {
+		t.Error(err)
+	}
+
+	// work which ignores the signal
+	release := make(chan struct{})
+	defer close(release)
+	start := time.Now()
+	err = dl.Run(func(stopper <-chan struct{}) error {
+		<-release
+		return nil
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed < 60*time.Millisecond {
+		t.Error("did not wait for the grace period", elapsed)
+	}
+
+	// work which finishes before the deadline
+	if err := dl.Run(takesFiveMillis); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeadlineRunWithPartial(t *testing.T) {
+	dl := New(20 * time.Millisecond)
+
+	// work which finishes in time
+	values, err := RunWithPartial(dl, func(stopper <-chan struct{}, partial chan<- int) error {
+		partial <- 1
+		partial <- 2
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(values) != 2 || values[0] != 1 || values[1] != 2 {
+		t.Error("wrong partial results", values)
+	}
+
+	// work which emits a few values then blocks, and keeps emitting after the deadline
+	done := make(chan struct{})
+	values, err = RunWithPartial(dl, func(stopper <-chan struct{}, partial chan<- int) error {
+		defer close(done)
+		for i := 1; i <= 3; i++ {
+			partial <- i
+		}
+		<-stopper
+		partial <- 4
+		return nil
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	<-done
+	if len(values) != 3 || values[0] != 1 || values[1] != 2 || values[2] != 3 {
+		t.Error("wrong partial results", values)
+	}
+}
+
+func TestDeadlineTimeoutError(t *testing.T) {
+	dl := NewWithGrace(10*time.Millisecond, 10*time.Millisecond)
+
+	err := dl.Run(takesTwentyMillis)
+	var timeoutErr *TimeoutError
+	if !errors.As(err, &timeoutErr) {
+		t.Fatal(err)
+	}
+	if timeoutErr.Timeout != 10*time.Millisecond {
+		t.Error("wrong timeout", timeoutErr.Timeout)
+	}
+	if timeoutErr.Elapsed < 20*time.Millisecond {
+		t.Error("wrong elapsed time", timeoutErr.Elapsed)
+	}
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error("timeout error does not match ErrTimedOut")
+	}
+	if err.Error() != "timed out waiting for function to finish after 10ms" {
+		t.Error(err)
+	}
+
+	release := make(chan struct{})
+	defer close(release)
+	err = dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	if !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Error(err)
+	}
+}
+
+func TestDeadlineTightest(t *testing.T) {
+	service := New(time.Second)
+	request := NewWithGrace(10*time.Millisecond, 5*time.Millisecond)
+	dl := Tightest(service, request, New(50*time.Millisecond))
+
+	var timeoutErr *TimeoutError
+	if err := dl.Run(takesTwentyMillis); !errors.As(err, &timeoutErr) || timeoutErr.Timeout != 10*time.Millisecond {
+		t.Error(err)
+	}
+	if err := dl.Run(takesFiveMillis); err != nil {
+		t.Error(err)
+	}
+	if dl == request {
+		t.Error("Tightest returned one of its arguments")
+	}
+
+	if err := Tightest(service).Run(takesTwentyMillis); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDeadlineWithObserver(t *testing.T) {
+	var timedOut []bool
+	var elapsed []time.Duration
+	dl := New(10 * time.Millisecond).WithObserver(func(breached bool, took time.Duration) {
+		timedOut = append(timedOut, breached)
+		elapsed = append(elapsed, took)
+	})
+
+	if err := dl.Run(takesTwentyMillis); !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+	if err := dl.Run(takesFiveMillis); err != nil {
+		t.Error(err)
+	}
+	err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if !errors.Is(err, ErrTimedOut) {
+		t.Error(err)
+	}
+
+	if len(timedOut) != 3 || !timedOut[0] || timedOut[1] || !timedOut[2] {
+		t.Fatal("wrong observations", timedOut, elapsed)
+	}
+	if elapsed[0] != 10*time.Millisecond || elapsed[2] != 10*time.Millisecond {
+		t.Error("timeout not observed", elapsed)
+	}
+	if elapsed[1] < 5*time.Millisecond || elapsed[1] >= 10*time.Millisecond {
+		t.Error("wrong elapsed time observed", elapsed[1])
+	}
+}
+
+func TestDeadlineNoLeaks(t *testing.T) {
+	dl := New(time.Hour)
+	before := runtime.NumGoroutine()
+
+	for i := 0; i < 1000; i++ {
+		if err := dl.Run(func(<-chan struct{}) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+
+		var workCtx context.Context
+		if err := dl.RunCtx(context.Background(), func(ctx context.Context) error {
+			workCtx = ctx
+			return nil
+		}); err != nil {
+			t.Fatal(err)
+		}
+		if workCtx.Err() != context.Canceled {
+			t.Fatal("context not cancelled on early return", workCtx.Err())
+		}
+	}
+
+	// give the work goroutines a moment to finish exiting after sending their results
+	time.Sleep(10 * time.Millisecond)
+	if after := runtime.NumGoroutine(); after > before+5 {
+		t.Errorf("goroutines leaked: %d before, %d after", before, after)
+	}
+}
+
 func ExampleDeadline() {
 	dl := New(1 * time.Second)
 
@@ -56,10 +415,10 @@ func ExampleDeadline() {
 		return nil
 	})
 
-	switch err {
-	case ErrTimedOut:
+	switch {
+	case errors.Is(err, ErrTimedOut):
 		// execution took too long, oops
-	default:
+	case err != nil:
 		// some other error
 	}
 }
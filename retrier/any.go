@@ -0,0 +1,44 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+)
+
+// RunAny runs each of the given work functions with the corresponding retrier (as with RunCtx), all in parallel,
+// and returns nil as soon as any of them succeeds, cancelling the context passed to the others. If none of them
+// succeeds, it waits for them all and returns their errors joined together (with errors.Join) in the order of
+// the retriers. The retriers and work functions must be the same length; RunAny panics otherwise. It returns
+// nil immediately if there is nothing to run.
+func RunAny(ctx context.Context, retriers []*Retrier, works []func(ctx context.Context) error) error {
+	if len(retriers) != len(works) {
+		panic("retrier: RunAny called with different numbers of retriers and work functions")
+	}
+	if len(retriers) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		index int
+		err   error
+	}
+	results := make(chan result, len(retriers))
+	for i := range retriers {
+		go func(i int) {
+			results <- result{i, retriers[i].RunCtx(ctx, works[i])}
+		}(i)
+	}
+
+	errs := make([]error, len(retriers))
+	for range retriers {
+		res := <-results
+		if res.err == nil {
+			return nil
+		}
+		errs[res.index] = res.err
+	}
+	return errors.Join(errs...)
+}
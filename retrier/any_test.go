@@ -0,0 +1,52 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunAny(t *testing.T) {
+	cancelled := make(chan struct{})
+	slow := func(ctx context.Context) error {
+		<-ctx.Done()
+		close(cancelled)
+		return ctx.Err()
+	}
+	attempts := 0
+	eventually := func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errFoo
+		}
+		return nil
+	}
+
+	retriers := []*Retrier{New(nil, nil), New(ConstantBackoff(5, time.Millisecond), nil)}
+	err := RunAny(context.Background(), retriers, []func(context.Context) error{slow, eventually})
+	if err != nil {
+		t.Error(err)
+	}
+	if attempts != 3 {
+		t.Error("wrong number of attempts", attempts)
+	}
+	select {
+	case <-cancelled:
+	case <-time.After(time.Second):
+		t.Error("other work was not cancelled")
+	}
+
+	fails := func(err error) func(context.Context) error {
+		return func(ctx context.Context) error { return err }
+	}
+	retriers = []*Retrier{New(ConstantBackoff(1, 0), nil), New(nil, nil)}
+	err = RunAny(context.Background(), retriers, []func(context.Context) error{fails(errFoo), fails(errBar)})
+	if !errors.Is(err, errFoo) || !errors.Is(err, errBar) {
+		t.Error("errors not aggregated", err)
+	}
+
+	if err := RunAny(context.Background(), nil, nil); err != nil {
+		t.Error(err)
+	}
+}
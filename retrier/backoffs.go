@@ -1,6 +1,9 @@
 package retrier
 
-import "time"
+import (
+	"math"
+	"time"
+)
 
 // ConstantBackoff generates a simple back-off strategy of retrying 'n' times, and waiting 'amount' time after each one.
 func ConstantBackoff(n int, amount time.Duration) []time.Duration {
@@ -39,3 +42,25 @@ func LimitedExponentialBackoff(n int, initialAmount time.Duration, limitAmount t
 	}
 	return ret
 }
+
+// ExponentialBackoffFactor generates a back-off strategy of retrying 'n' times, and multiplying the amount of
+// time waited by 'factor' after each one, never waiting more than 'maxAmount'. A factor of 1 or less simply
+// generates a constant back-off of 'initialAmount' (still limited to 'maxAmount').
+func ExponentialBackoffFactor(n int, initialAmount time.Duration, factor float64, maxAmount time.Duration) []time.Duration {
+	if factor <= 1 {
+		return ConstantBackoff(n, min(initialAmount, maxAmount))
+	}
+
+	ret := make([]time.Duration, n)
+	next := float64(initialAmount)
+	for i := range ret {
+		// compare as floats, since next may overflow a time.Duration long before it overflows a float64
+		if next >= float64(maxAmount) {
+			ret[i] = maxAmount
+		} else {
+			ret[i] = time.Duration(next)
+		}
+		next = math.Min(next*factor, math.MaxInt64)
+	}
+	return ret
+}
@@ -1,6 +1,7 @@
 package retrier
 
 import (
+	"math"
 	"testing"
 	"time"
 )
@@ -83,3 +84,32 @@ func TestLimitedExponentialBackoff(t *testing.T) {
 		t.Error("incorrect value")
 	}
 }
+
+func TestExponentialBackoffFactor(t *testing.T) {
+	b := ExponentialBackoffFactor(5, 100*time.Millisecond, 1.5, 300*time.Millisecond)
+	expected := []time.Duration{100 * time.Millisecond, 150 * time.Millisecond, 225 * time.Millisecond,
+		300 * time.Millisecond, 300 * time.Millisecond}
+	if len(b) != len(expected) {
+		t.Fatal("incorrect length")
+	}
+	for i := range expected {
+		if b[i] != expected[i] {
+			t.Error("incorrect value", i, b[i])
+		}
+	}
+
+	b = ExponentialBackoffFactor(3, 1*time.Second, 0.5, 1*time.Minute)
+	if len(b) != 3 || b[0] != 1*time.Second || b[2] != 1*time.Second {
+		t.Error("factor <= 1 not treated as constant", b)
+	}
+
+	b = ExponentialBackoffFactor(100, 1*time.Second, 3, math.MaxInt64)
+	for i := 1; i < len(b); i++ {
+		if b[i] < b[i-1] {
+			t.Fatal("back-off overflowed", i, b[i])
+		}
+	}
+	if b[99] != math.MaxInt64 {
+		t.Error("incorrect value", b[99])
+	}
+}
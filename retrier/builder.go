@@ -0,0 +1,84 @@
+package retrier
+
+import (
+	"errors"
+	"time"
+)
+
+// ErrConflictingOptions is the error returned by Builder.Build when the retrier is configured to retry
+// infinitely but also to stop after a maximum number of attempts.
+var ErrConflictingOptions = errors.New("infinite retry conflicts with max attempts")
+
+// ErrInvalidJitter is the error returned by Builder.Build when the jitter is outside the range 0.0 to 1.0.
+var ErrInvalidJitter = errors.New("jitter must be between 0.0 and 1.0")
+
+// Builder constructs a Retrier from a chain of options, validating them all at once when Build is called.
+// It is purely a more readable alternative to calling New and then the Retrier's With* and Set* methods.
+type Builder struct {
+	backoff     []time.Duration
+	class       Classifier
+	jitter      float64
+	infinite    bool
+	maxAttempts int
+	notify      func(err error, attempt int, backoff time.Duration)
+}
+
+// NewBuilder constructs an empty Builder. Without any options, it builds the same Retrier as New(nil, nil).
+func NewBuilder() *Builder {
+	return &Builder{}
+}
+
+// Backoff sets the back-off pattern, as passed to New.
+func (b *Builder) Backoff(backoff []time.Duration) *Builder {
+	b.backoff = backoff
+	return b
+}
+
+// Classifier sets the classifier, as passed to New.
+func (b *Builder) Classifier(class Classifier) *Builder {
+	b.class = class
+	return b
+}
+
+// Jitter sets the jitter, as with Retrier.SetJitter.
+func (b *Builder) Jitter(jit float64) *Builder {
+	b.jitter = jit
+	return b
+}
+
+// Infinite sets the retrier to retry infinitely, as with Retrier.WithInfiniteRetry.
+func (b *Builder) Infinite() *Builder {
+	b.infinite = true
+	return b
+}
+
+// MaxAttempts sets the maximum number of attempts, as with Retrier.WithMaxAttempts.
+func (b *Builder) MaxAttempts(n int) *Builder {
+	b.maxAttempts = n
+	return b
+}
+
+// Notify sets the retry notification function, as with Retrier.WithNotify.
+func (b *Builder) Notify(fn func(err error, attempt int, backoff time.Duration)) *Builder {
+	b.notify = fn
+	return b
+}
+
+// Build validates the options and constructs the Retrier. Unlike the Retrier's methods, which silently ignore
+// invalid values, it returns an error if any option is invalid or if the options conflict with each other.
+func (b *Builder) Build() (*Retrier, error) {
+	if b.infinite && b.maxAttempts > 0 {
+		return nil, ErrConflictingOptions
+	}
+	if b.jitter < 0 || b.jitter > 1 {
+		return nil, ErrInvalidJitter
+	}
+
+	r := New(b.backoff, b.class).WithNotify(b.notify).WithMaxAttempts(b.maxAttempts)
+	r.SetJitter(b.jitter)
+	if b.infinite {
+		r.WithInfiniteRetry()
+	}
+
+	return r, nil
+}
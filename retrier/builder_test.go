@@ -0,0 +1,66 @@
+package retrier
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBuilder(t *testing.T) {
+	notified := 0
+	notify := func(err error, attempt int, backoff time.Duration) {
+		notified++
+	}
+
+	r, err := NewBuilder().
+		Backoff(ConstantBackoff(5, 0)).
+		Classifier(WhitelistClassifier{errFoo}).
+		Jitter(0.25).
+		MaxAttempts(3).
+		Notify(notify).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := New(ConstantBackoff(5, 0), WhitelistClassifier{errFoo}).WithMaxAttempts(3).WithNotify(notify)
+	expected.SetJitter(0.25)
+
+	if len(r.backoff) != len(expected.backoff) || r.jitter != expected.jitter ||
+		r.maxAttempts != expected.maxAttempts || r.infiniteRetry != expected.infiniteRetry {
+		t.Error("built retrier does not match")
+	}
+
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	if i != 3 {
+		t.Error("run wrong number of times")
+	}
+	if notified != 2 {
+		t.Error("notified wrong number of times", notified)
+	}
+
+	r, err = NewBuilder().Infinite().Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !r.infiniteRetry || r.maxAttempts != 0 {
+		t.Error("built retrier does not match")
+	}
+	if _, ok := r.class.(DefaultClassifier); !ok {
+		t.Error("default classifier not used")
+	}
+}
+
+func TestBuilderConflicts(t *testing.T) {
+	if _, err := NewBuilder().Infinite().MaxAttempts(3).Build(); err != ErrConflictingOptions {
+		t.Error(err)
+	}
+	if _, err := NewBuilder().Jitter(2).Build(); err != ErrInvalidJitter {
+		t.Error(err)
+	}
+	if _, err := NewBuilder().Jitter(-1).Build(); err != ErrInvalidJitter {
+		t.Error(err)
+	}
+}
@@ -1,6 +1,12 @@
 package retrier
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"time"
+)
 
 // Action is the type returned by a Classifier to indicate how the Retrier should proceed.
 type Action int
@@ -16,6 +22,15 @@ type Classifier interface {
 	Classify(error) Action
 }
 
+// BackoffClassifier is the interface implemented by classifiers which also decide how long to back off
+// before retrying. When ClassifyBackoff returns Retry and true, the Retrier waits for the returned duration
+// instead of the next duration in its back-off pattern (jitter is not applied). A Retrier uses
+// ClassifyBackoff instead of Classify for any classifier implementing this interface.
+type BackoffClassifier interface {
+	Classifier
+	ClassifyBackoff(error) (Action, time.Duration, bool)
+}
+
 // DefaultClassifier classifies errors in the simplest way possible. If
 // the error is nil, it returns Succeed, otherwise it returns Retry.
 type DefaultClassifier struct{}
@@ -66,3 +81,30 @@ func (list BlacklistClassifier) Classify(err error) Action {
 
 	return Retry
 }
+
+// TransientClassifier classifies errors based on whether they look like transient network failures.
+// If the error is nil, it returns Succeed. It returns Retry if the error (or any error it wraps) is:
+//   - a net.Error whose Timeout() or Temporary() method returns true
+//   - io.ErrUnexpectedEOF
+//   - context.DeadlineExceeded (e.g. from a sub-operation with its own timeout)
+//
+// Otherwise, it returns Fail.
+type TransientClassifier struct{}
+
+// Classify implements the Classifier interface.
+func (c TransientClassifier) Classify(err error) Action {
+	if err == nil {
+		return Succeed
+	}
+
+	if errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, context.DeadlineExceeded) {
+		return Retry
+	}
+
+	var netErr net.Error
+	if errors.As(err, &netErr) && (netErr.Timeout() || netErr.Temporary()) {
+		return Retry
+	}
+
+	return Fail
+}
@@ -1,7 +1,10 @@
 package retrier
 
 import (
+	"context"
 	"errors"
+	"io"
+	"net"
 	"testing"
 )
 
@@ -96,3 +99,48 @@ func TestBlacklistClassifier(t *testing.T) {
 		t.Error("blacklist misclassified baz")
 	}
 }
+
+type netErr struct {
+	timeout, temporary bool
+}
+
+func (e netErr) Error() string   { return "network error" }
+func (e netErr) Timeout() bool   { return e.timeout }
+func (e netErr) Temporary() bool { return e.temporary }
+
+func TestTransientClassifier(t *testing.T) {
+	c := TransientClassifier{}
+
+	if c.Classify(nil) != Succeed {
+		t.Error("transient misclassified nil")
+	}
+
+	if c.Classify(netErr{timeout: true}) != Retry {
+		t.Error("transient misclassified timeout")
+	}
+	if c.Classify(netErr{temporary: true}) != Retry {
+		t.Error("transient misclassified temporary")
+	}
+	if c.Classify(netErr{}) != Fail {
+		t.Error("transient misclassified permanent network error")
+	}
+	if c.Classify(&net.OpError{Op: "dial", Err: netErr{timeout: true}}) != Retry {
+		t.Error("transient misclassified dial timeout")
+	}
+	if c.Classify(io.ErrUnexpectedEOF) != Retry {
+		t.Error("transient misclassified unexpected EOF")
+	}
+	if c.Classify(wrappedErr{error: context.DeadlineExceeded}) != Retry {
+		t.Error("transient misclassified deadline exceeded")
+	}
+
+	if c.Classify(io.EOF) != Fail {
+		t.Error("transient misclassified EOF")
+	}
+	if c.Classify(context.Canceled) != Fail {
+		t.Error("transient misclassified canceled")
+	}
+	if c.Classify(errFoo) != Fail {
+		t.Error("transient misclassified foo")
+	}
+}
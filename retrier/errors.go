@@ -1,6 +1,22 @@
 package retrier
 
-import "time"
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// ErrInvalidJitterBounds is the error returned by SetJitterBounds when the bounds do not have exactly one
+// (non-negative) entry for each entry in the backoff slice.
+var ErrInvalidJitterBounds = errors.New("jitter bounds must have one non-negative entry per back-off")
+
+// BackoffHinter is the interface implemented by errors which carry a hint of how long to back off before
+// retrying (such as a RetryInfo from the server). When RetryBackoff returns true, the Retrier waits for that
+// duration instead of the next duration in its back-off pattern (jitter is not applied). Hints are found
+// anywhere in the chain of wrapped errors, and take priority over those from a BackoffClassifier.
+type BackoffHinter interface {
+	RetryBackoff() (time.Duration, bool)
+}
 
 type errWithBackoff struct {
 	err     error
@@ -17,3 +33,69 @@ func ErrWithBackoff(err error, backoff time.Duration) error {
 func (e *errWithBackoff) Error() string {
 	return e.err.Error()
 }
+
+// RetryBackoff implements the BackoffHinter interface.
+func (e *errWithBackoff) RetryBackoff() (time.Duration, bool) {
+	return e.backoff, true
+}
+
+// PanicError is the error an attempt fails with when a Retrier configured WithRecoverIf recovers a panic
+// from the work function and chooses to retry it. It is also the error returned to callers of RunSharedCtx
+// which were waiting on a shared run that panicked.
+type PanicError struct {
+	Value any    // Value is the value passed to panic.
+	Stack []byte // Stack is the stack trace of the goroutine at the time of the panic.
+}
+
+func (e *PanicError) Error() string {
+	return fmt.Sprintf("retrier: recovered panic: %v", e.Value)
+}
+
+// ExhaustedError is the error returned by a Retrier configured WithExhaustionError when the work function
+// is still failing with a retriable error after all retries have been used up. It unwraps to the last error
+// returned by the work function, so errors.Is and errors.As continue to match that error.
+type ExhaustedError struct {
+	Attempts int   // Attempts is the total number of times the work function was executed.
+	Last     error // Last is the error returned by the final execution of the work function.
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("retries exhausted after %d attempts: %v", e.Attempts, e.Last)
+}
+
+// Unwrap returns the last error returned by the work function.
+func (e *ExhaustedError) Unwrap() error {
+	return e.Last
+}
+
+// IsExhausted reports whether an error returned by a Retrier means that it gave up after using all its
+// retries, as opposed to failing fast because the classifier returned Fail or stopping because the context
+// was done. It relies on ExhaustedError, so it only ever returns true for retriers configured
+// WithExhaustionError.
+func IsExhausted(err error) bool {
+	var exhausted *ExhaustedError
+	return errors.As(err, &exhausted)
+}
+
+// aggregateErrors returns the last error if all the given errors are the same (as determined by errors.Is),
+// and otherwise joins the distinct errors together in the order they were first seen.
+func aggregateErrors(errs []error) error {
+	var distinct []error
+	for _, err := range errs {
+		seen := false
+		for _, prev := range distinct {
+			if errors.Is(err, prev) {
+				seen = true
+				break
+			}
+		}
+		if !seen {
+			distinct = append(distinct, err)
+		}
+	}
+
+	if len(distinct) == 1 {
+		return errs[len(errs)-1]
+	}
+	return errors.Join(distinct...)
+}
@@ -0,0 +1,65 @@
+package retrier
+
+import (
+	"context"
+	"sync"
+)
+
+// Gate allows attempts to be paused and resumed across any number of retriers configured WithGate, for
+// example during a maintenance window of the dependency they call. The zero value is an open (not paused)
+// gate, and it is safe to use a Gate concurrently.
+type Gate struct {
+	lock   sync.Mutex
+	paused chan struct{} // closed on Resume; nil unless paused
+}
+
+// Pause pauses the gate, so that attempts wait until Resume is called. Attempts already in progress are not
+// affected. Pausing a paused gate does nothing.
+func (g *Gate) Pause() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.paused == nil {
+		g.paused = make(chan struct{})
+	}
+}
+
+// Resume resumes the gate, releasing all waiting attempts at once. Resuming an open gate does nothing.
+func (g *Gate) Resume() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if g.paused != nil {
+		close(g.paused)
+		g.paused = nil
+	}
+}
+
+// IsPaused reports whether the gate is paused at that instant.
+func (g *Gate) IsPaused() bool {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	return g.paused != nil
+}
+
+// wait waits until the gate is open or the context is done. A nil gate is always open.
+func (g *Gate) wait(ctx context.Context) error {
+	if g == nil {
+		return nil
+	}
+
+	g.lock.Lock()
+	paused := g.paused
+	g.lock.Unlock()
+
+	if paused == nil {
+		return nil
+	}
+	select {
+	case <-paused:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	}
+}
@@ -0,0 +1,68 @@
+package retrier
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrierWithGate(t *testing.T) {
+	gate := &Gate{}
+	r1 := New([]time.Duration{0}, nil).WithGate(gate)
+	r2 := New([]time.Duration{0}, nil).WithGate(gate)
+
+	if err := r1.Run(genWork(nil)); err != nil {
+		t.Error(err)
+	}
+
+	gate.Pause()
+	if !gate.IsPaused() {
+		t.Error("gate not paused")
+	}
+
+	var calls int32
+	work := func() error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+	done := make(chan error, 2)
+	go func() { done <- r1.Run(work) }()
+	go func() { done <- r2.Run(work) }()
+
+	time.Sleep(20 * time.Millisecond)
+	if atomic.LoadInt32(&calls) != 0 {
+		t.Error("paused gate did not block attempts")
+	}
+
+	gate.Resume()
+	for i := 0; i < 2; i++ {
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+	}
+	if calls != 2 {
+		t.Error("resumed gate did not release attempts", calls)
+	}
+	if gate.IsPaused() {
+		t.Error("gate still paused")
+	}
+}
+
+func TestRetrierWithGateCancelled(t *testing.T) {
+	gate := &Gate{}
+	r := New([]time.Duration{0}, nil).WithGate(gate)
+
+	gate.Pause()
+	defer gate.Resume()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		t.Error("attempt ran while the gate was paused")
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+}
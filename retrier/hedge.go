@@ -0,0 +1,107 @@
+package retrier
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// RunHedgedCtx executes the given work function, and if it has not returned within hedgeDelay, launches
+// another ("hedged") attempt concurrently, repeating every hedgeDelay until up to maxHedges additional
+// attempts are in flight (values of maxHedges less than 0 are treated as 0). If every attempt in flight has
+// failed with a retriable error, the next hedge is launched immediately rather than waiting for the delay. The
+// result of the first attempt which the classifier deems a Succeed or Fail is returned, and the context passed
+// to the remaining attempts is cancelled. The errors of the other attempts are ignored unless every attempt
+// fails with a retriable error, in which case the last such error is returned. If the context is done first,
+// context.Cause(ctx) is returned.
+//
+// The back-off pattern is not used; hedging reduces tail latency for idempotent work rather than waiting
+// between failures, so the options which govern back-offs and retries (including jitter, WithNotify,
+// WithMaxAttempts, WithMaxElapsedTime, WithCleanup, WithFinalAttempt and WithExhaustionError) do not apply.
+// Otherwise a hedged run behaves like any other: WithMaxConcurrent, WithContextValues, WithIdempotencyKey (with
+// one key shared by every attempt), WithDeadlineReserve, WithMetrics and WithOnSuccess apply to the run as a
+// whole; WithGate is waited on before the first attempt; and WithAttemptTimeouts (indexed by the order in which
+// attempts are launched), WithRecoverIf and the classifier observers apply to each attempt. Each hedge launched
+// is logged at debug level if WithLogger is set.
+func (r *Retrier) RunHedgedCtx(ctx context.Context, work func(ctx context.Context) error,
+	hedgeDelay time.Duration, maxHedges int) error {
+	err := r.runHedged(ctx, work, hedgeDelay, max(maxHedges, 0))
+	r.countRun(err)
+	return err
+}
+
+func (r *Retrier) runHedged(ctx context.Context, work func(ctx context.Context) error,
+	hedgeDelay time.Duration, maxHedges int) error {
+	ctx, end, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	if err := r.gate.wait(ctx); err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	attempt := func(ctx context.Context, _ int) error {
+		return work(ctx)
+	}
+	start := r.clock.Now()
+	results := make(chan error, maxHedges+1)
+	launched, finished := 0, 0
+	launch := func() {
+		if launched > 0 && r.logger != nil {
+			r.logger.LogAttrs(ctx, slog.LevelDebug, "launching hedged attempt", slog.Int("attempt", launched+1))
+		}
+		index := launched
+		launched++
+		go func() {
+			results <- r.runAttempt(ctx, index, start, attempt)
+		}()
+	}
+
+	launch()
+	timer := time.NewTimer(hedgeDelay)
+	defer timer.Stop()
+
+	for {
+		var hedge <-chan time.Time
+		if launched <= maxHedges {
+			hedge = timer.C
+		}
+
+		select {
+		case ret := <-results:
+			finished++
+			if action, _, _ := r.classify(ret); action != Retry {
+				if ret == nil && r.onSuccess != nil {
+					r.onSuccess(launched, r.clock.Now().Sub(start))
+				}
+				return ret
+			}
+			if ctx.Err() != nil {
+				// the attempt most likely failed because the context is done
+				return context.Cause(ctx)
+			}
+			if finished < launched {
+				continue
+			}
+			if launched > maxHedges {
+				return ret
+			}
+			// everything in flight has failed, so there's no point waiting to hedge
+			if !timer.Stop() {
+				<-timer.C
+			}
+			launch()
+			timer.Reset(hedgeDelay)
+		case <-hedge:
+			launch()
+			timer.Reset(hedgeDelay)
+		case <-ctx.Done():
+			return context.Cause(ctx)
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrierRunHedgedCtx(t *testing.T) {
+	r := New(nil, nil)
+
+	var started, cancelled int32
+	start := time.Now()
+	err := r.RunHedgedCtx(context.Background(), func(ctx context.Context) error {
+		if atomic.AddInt32(&started, 1) == 1 {
+			// the first attempt is slow
+			select {
+			case <-ctx.Done():
+				atomic.AddInt32(&cancelled, 1)
+				return ctx.Err()
+			case <-time.After(1 * time.Second):
+				return nil
+			}
+		}
+		return nil
+	}, 10*time.Millisecond, 2)
+	if err != nil {
+		t.Error(err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Error("hedge did not win", elapsed)
+	}
+	if n := atomic.LoadInt32(&started); n != 2 {
+		t.Error("wrong number of attempts", n)
+	}
+
+	// the loser is cancelled
+	time.Sleep(10 * time.Millisecond)
+	if n := atomic.LoadInt32(&cancelled); n != 1 {
+		t.Error("slow attempt was not cancelled")
+	}
+}
+
+func TestRetrierRunHedgedCtxAllFail(t *testing.T) {
+	r := New(nil, nil)
+
+	var started int32
+	err := r.RunHedgedCtx(context.Background(), func(ctx context.Context) error {
+		if atomic.AddInt32(&started, 1) == 3 {
+			return errBar
+		}
+		return errFoo
+	}, 1*time.Second, 2)
+	if err != errBar {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&started); n != 3 {
+		t.Error("wrong number of attempts", n)
+	}
+
+	// non-retriable errors are returned immediately
+	r = New(nil, WhitelistClassifier{})
+	started = 0
+	err = r.RunHedgedCtx(context.Background(), func(ctx context.Context) error {
+		atomic.AddInt32(&started, 1)
+		return errBaz
+	}, 1*time.Second, 2)
+	if err != errBaz {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&started); n != 1 {
+		t.Error("wrong number of attempts", n)
+	}
+}
+
+func TestRetrierRunHedgedCtxCancelled(t *testing.T) {
+	r := New(nil, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	err := r.RunHedgedCtx(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return errFoo
+	}, 1*time.Millisecond, 2)
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	// the cause is returned, as from the other Run methods
+	errShutdown := errors.New("shutting down")
+	cctx, ccancel := context.WithCancelCause(context.Background())
+	time.AfterFunc(10*time.Millisecond, func() { ccancel(errShutdown) })
+	err = r.RunHedgedCtx(cctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, time.Millisecond, 2)
+	if err != errShutdown {
+		t.Error(err)
+	}
+}
+
+func TestRetrierRunHedgedCtxNegativeHedges(t *testing.T) {
+	r := New(nil, nil)
+
+	// treated as no hedging at all
+	var started int32
+	err := r.RunHedgedCtx(context.Background(), func(ctx context.Context) error {
+		atomic.AddInt32(&started, 1)
+		return errFoo
+	}, time.Millisecond, -5)
+	if err != errFoo {
+		t.Error(err)
+	}
+	if n := atomic.LoadInt32(&started); n != 1 {
+		t.Error("wrong number of attempts", n)
+	}
+}
+
+// atomicMetrics is a Metrics which can be used by concurrent attempts.
+type atomicMetrics struct {
+	NoopMetrics
+	attempts, successes, failures atomic.Int32
+}
+
+func (m *atomicMetrics) IncAttempt() { m.attempts.Add(1) }
+func (m *atomicMetrics) IncSuccess() { m.successes.Add(1) }
+func (m *atomicMetrics) IncFailure() { m.failures.Add(1) }
+
+func TestRetrierRunHedgedCtxOptions(t *testing.T) {
+	type key struct{}
+	m := &atomicMetrics{}
+	var succeeded []int
+	r := New(nil, nil).WithMetrics(m).WithContextValues(map[any]any{key{}: "value"}).
+		WithOnSuccess(func(attempts int, totalElapsed time.Duration) {
+			succeeded = append(succeeded, attempts)
+		})
+
+	var started int32
+	err := r.RunHedgedCtx(context.Background(), func(ctx context.Context) error {
+		if ctx.Value(key{}) != "value" {
+			return errBar
+		}
+		if atomic.AddInt32(&started, 1) == 1 {
+			<-ctx.Done()
+			return ctx.Err()
+		}
+		return nil
+	}, time.Millisecond, 1)
+	if err != nil {
+		t.Error(err)
+	}
+	if m.attempts.Load() != 2 || m.successes.Load() != 1 || m.failures.Load() != 0 {
+		t.Error("wrong metrics", m.attempts.Load(), m.successes.Load(), m.failures.Load())
+	}
+	if len(succeeded) != 1 || succeeded[0] != 2 {
+		t.Error("wrong successes reported", succeeded)
+	}
+}
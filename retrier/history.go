@@ -0,0 +1,28 @@
+package retrier
+
+import (
+	"context"
+	"time"
+)
+
+// AttemptRecord records the details of a single attempt made by RunWithHistoryCtx.
+type AttemptRecord struct {
+	Index      int           // the index of the attempt, starting from 0
+	Err        error         // the error the attempt returned (after any result validator)
+	Start, End time.Time     // when the attempt started and finished
+	Backoff    time.Duration // the back-off waited after the attempt, or 0 if it was not retried
+}
+
+// History is the record of every attempt made by RunWithHistoryCtx, in order.
+type History []AttemptRecord
+
+// RunWithHistoryCtx is like RunCtx, but also returns a record of every attempt it made. This is intended for
+// debugging and testing; the history of a run which never made an attempt (e.g. because the context was done
+// while it was waiting for WithMaxConcurrent or WithGate) is empty.
+func (r *Retrier) RunWithHistoryCtx(ctx context.Context, work func(ctx context.Context) error) (History, error) {
+	var history History
+	err := r.runFn(ctx, func(c context.Context, r int) error {
+		return work(c)
+	}, &history)
+	return history, err
+}
@@ -0,0 +1,47 @@
+package retrier
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestRetrierRunWithHistoryCtx(t *testing.T) {
+	r := New([]time.Duration{5 * time.Millisecond, 10 * time.Millisecond}, nil)
+
+	st := time.Now()
+	calls := 0
+	history, err := r.RunWithHistoryCtx(context.Background(), func(ctx context.Context) error {
+		calls++
+		time.Sleep(time.Millisecond)
+		if calls < 3 {
+			return errFoo
+		}
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(history) != calls {
+		t.Fatal("wrong history length", len(history), calls)
+	}
+
+	backoffs := []time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 0}
+	for i, record := range history {
+		if record.Index != i {
+			t.Error("wrong index", i, record.Index)
+		}
+		if record.Backoff != backoffs[i] {
+			t.Error("wrong backoff", i, record.Backoff)
+		}
+		if record.Start.Before(st) || record.End.Sub(record.Start) < time.Millisecond {
+			t.Error("wrong timing", i, record.Start, record.End)
+		}
+		if i > 0 && record.Start.Sub(history[i-1].End) < history[i-1].Backoff {
+			t.Error("attempt started before the back-off ended", i)
+		}
+	}
+	if history[0].Err != errFoo || history[1].Err != errFoo || history[2].Err != nil {
+		t.Error("wrong errors", history)
+	}
+}
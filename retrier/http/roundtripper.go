@@ -0,0 +1,150 @@
+// Package retrierhttp provides an http.RoundTripper which retries requests using a retrier.Retrier, so that the
+// retrier package itself does not depend on net/http.
+package retrierhttp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// ErrRetryableStatus is matched (using errors.Is) by the StatusError the RoundTripper's retrier sees when a
+// response has a status code which may succeed if retried.
+var ErrRetryableStatus = errors.New("retryable HTTP status")
+
+// StatusError is the error passed to the retrier's classifier when a response has a retryable status code
+// (429 Too Many Requests, 502 Bad Gateway, 503 Service Unavailable or 504 Gateway Timeout). If the response had
+// a valid Retry-After header then the retrier waits for that long instead of its own back-off. StatusErrors are
+// never returned from RoundTrip: if the retrier gives up, the last response is returned as usual.
+type StatusError struct {
+	StatusCode int
+	RetryAfter time.Duration // zero if the response had no valid Retry-After header
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("retryable HTTP status %d", e.StatusCode)
+}
+
+// Is reports whether target is ErrRetryableStatus.
+func (e *StatusError) Is(target error) bool {
+	return target == ErrRetryableStatus
+}
+
+// RetryBackoff implements the retrier.BackoffHinter interface.
+func (e *StatusError) RetryBackoff() (time.Duration, bool) {
+	return e.RetryAfter, e.RetryAfter > 0
+}
+
+// RoundTripper is an http.RoundTripper which retries requests that fail (with an error or a retryable status
+// code) according to a Retrier. Requests are only retried if sending them again is safe: they must either use an
+// idempotent method (GET, HEAD, OPTIONS, TRACE, PUT or DELETE) or carry an Idempotency-Key header, and they must
+// either have no body or have a body which can be rewound with GetBody. In particular, an ordinary POST is sent
+// exactly once, even though http.NewRequest sets GetBody for it; add an Idempotency-Key header (which the server
+// must honour) to have it retried. Each attempt is sent with the context the retrier passes to the work
+// function, so per-attempt timeouts and context values apply to it.
+type RoundTripper struct {
+	retrier *retrier.Retrier
+	base    http.RoundTripper
+}
+
+// NewRoundTripper constructs a RoundTripper which sends requests with base (or http.DefaultTransport if base is
+// nil), retrying them using r. The errors seen by r's classifier are those returned by base, and StatusErrors
+// for responses with retryable status codes.
+func NewRoundTripper(r *retrier.Retrier, base http.RoundTripper) *RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &RoundTripper{
+		retrier: r,
+		base:    base,
+	}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *RoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	if !retryable(req) {
+		return t.base.RoundTrip(req)
+	}
+
+	var resp *http.Response
+	attempt := 0
+	err := t.retrier.RunCtx(req.Context(), func(ctx context.Context) error {
+		if resp != nil {
+			// discard the response to the previous attempt so its connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			resp = nil
+		}
+
+		attemptReq := req.Clone(ctx)
+		if attempt > 0 && req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return err
+			}
+			attemptReq.Body = body
+		}
+		attempt++
+
+		var err error
+		resp, err = t.base.RoundTrip(attemptReq)
+		if err != nil {
+			return err
+		}
+		return statusError(resp)
+	})
+
+	var statusErr *StatusError
+	if resp != nil && (err == nil || errors.As(err, &statusErr)) {
+		return resp, nil
+	}
+	if resp != nil {
+		resp.Body.Close()
+	}
+	return nil, err
+}
+
+func retryable(req *http.Request) bool {
+	if req.GetBody == nil && req.Body != nil && req.Body != http.NoBody {
+		return false
+	}
+	if req.Header.Get("Idempotency-Key") != "" {
+		return true
+	}
+	switch req.Method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+func statusError(resp *http.Response) error {
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return &StatusError{
+			StatusCode: resp.StatusCode,
+			RetryAfter: retryAfter(resp.Header.Get("Retry-After")),
+		}
+	}
+	return nil
+}
+
+// retryAfter parses a Retry-After header, which is either a number of seconds or an HTTP date.
+func retryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return max(time.Duration(seconds)*time.Second, 0)
+	}
+	if date, err := http.ParseTime(header); err == nil {
+		return max(time.Until(date), 0)
+	}
+	return 0
+}
@@ -0,0 +1,172 @@
+package retrierhttp
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// flakyServer returns 503 (with the given Retry-After header) to the first failures requests, then 200 echoing
+// the request body.
+func flakyServer(failures int32, retryAfter string) (*httptest.Server, *atomic.Int32) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= failures {
+			if retryAfter != "" {
+				w.Header().Set("Retry-After", retryAfter)
+			}
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		io.Copy(w, r.Body)
+	}))
+	return server, &requests
+}
+
+func TestRoundTripperRetries(t *testing.T) {
+	server, requests := flakyServer(2, "")
+	defer server.Close()
+
+	r := retrier.New(retrier.ConstantBackoff(3, time.Millisecond), nil)
+	client := &http.Client{Transport: NewRoundTripper(r, nil)}
+
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", "abc123")
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "hello" {
+		t.Error("wrong response", resp.StatusCode, string(body))
+	}
+	if requests.Load() != 3 {
+		t.Error("wrong number of requests", requests.Load())
+	}
+}
+
+func TestRoundTripperRetryAfter(t *testing.T) {
+	server, requests := flakyServer(1, "1")
+	defer server.Close()
+
+	r := retrier.New(retrier.ConstantBackoff(1, time.Millisecond), nil)
+	client := &http.Client{Transport: NewRoundTripper(r, nil)}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Error("wrong response", resp.StatusCode, requests.Load())
+	}
+	if time.Since(start) < time.Second {
+		t.Error("Retry-After was not honoured", time.Since(start))
+	}
+}
+
+func TestRoundTripperExhausted(t *testing.T) {
+	server, requests := flakyServer(5, "")
+	defer server.Close()
+
+	r := retrier.New(retrier.ConstantBackoff(2, time.Millisecond), nil)
+	client := &http.Client{Transport: NewRoundTripper(r, nil)}
+
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 3 {
+		t.Error("wrong response", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestRoundTripperNotRetryable(t *testing.T) {
+	server, requests := flakyServer(2, "")
+	defer server.Close()
+
+	r := retrier.New(retrier.ConstantBackoff(3, time.Millisecond), nil)
+	client := &http.Client{Transport: NewRoundTripper(r, nil)}
+
+	// an ordinary POST is not idempotent, even though its body can be rewound
+	resp, err := client.Post(server.URL, "text/plain", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 1 {
+		t.Error("POST was retried", resp.StatusCode, requests.Load())
+	}
+
+	// and an idempotency key does not help if the body cannot be rewound
+	req, err := http.NewRequest(http.MethodPost, server.URL, strings.NewReader("hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Idempotency-Key", "abc123")
+	req.GetBody = nil
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable || requests.Load() != 2 {
+		t.Error("POST without GetBody was retried", resp.StatusCode, requests.Load())
+	}
+}
+
+func TestRoundTripperAttemptTimeout(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) == 1 {
+			select {
+			case <-r.Context().Done():
+			case <-time.After(300 * time.Millisecond):
+			}
+		}
+	}))
+	defer server.Close()
+
+	r := retrier.New(retrier.ConstantBackoff(1, time.Millisecond), nil).
+		WithAttemptTimeouts([]time.Duration{20 * time.Millisecond, time.Second})
+	client := &http.Client{Transport: NewRoundTripper(r, nil)}
+
+	// a GET has no body, but must still be sent with each attempt's own context
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || requests.Load() != 2 {
+		t.Error("GET was not retried after its attempt timed out", resp.StatusCode, requests.Load())
+	}
+	if elapsed := time.Since(start); elapsed >= 300*time.Millisecond {
+		t.Error("attempt timeout was not honoured", elapsed)
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	if retryAfter("") != 0 || retryAfter("garbage") != 0 || retryAfter("-3") != 0 {
+		t.Error("invalid Retry-After parsed")
+	}
+	if retryAfter("2") != 2*time.Second {
+		t.Error("wrong seconds", retryAfter("2"))
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := retryAfter(date); d < 58*time.Second || d > time.Minute {
+		t.Error("wrong date", d)
+	}
+}
@@ -0,0 +1,13 @@
+package retrier
+
+import "context"
+
+// idempotencyKeyCtx is the context key under which a run's idempotency key is stored.
+type idempotencyKeyCtx struct{}
+
+// IdempotencyKeyFromContext returns the idempotency key of the run the given context was passed to by a
+// Retrier configured WithIdempotencyKey, or false if there is none.
+func IdempotencyKeyFromContext(ctx context.Context) (string, bool) {
+	key, ok := ctx.Value(idempotencyKeyCtx{}).(string)
+	return key, ok
+}
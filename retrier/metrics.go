@@ -0,0 +1,32 @@
+package retrier
+
+import "time"
+
+// Metrics is the interface implemented by anything that can record metrics about a Retrier,
+// allowing any metrics backend to be plugged in with a single object. Its methods may be called concurrently,
+// both by concurrent runs and by the concurrent attempts of RunHedgedCtx.
+type Metrics interface {
+	// IncAttempt is called every time the work function is executed.
+	IncAttempt()
+	// IncSuccess is called every time a run ends successfully (returns nil).
+	IncSuccess()
+	// IncFailure is called every time a run ends in failure (returns a non-nil error).
+	IncFailure()
+	// ObserveBackoff is called with every back-off the retrier is about to wait for.
+	ObserveBackoff(time.Duration)
+}
+
+// NoopMetrics implements the Metrics interface by discarding everything. It is the default.
+type NoopMetrics struct{}
+
+// IncAttempt implements the Metrics interface.
+func (NoopMetrics) IncAttempt() {}
+
+// IncSuccess implements the Metrics interface.
+func (NoopMetrics) IncSuccess() {}
+
+// IncFailure implements the Metrics interface.
+func (NoopMetrics) IncFailure() {}
+
+// ObserveBackoff implements the Metrics interface.
+func (NoopMetrics) ObserveBackoff(time.Duration) {}
@@ -0,0 +1,49 @@
+package retrier
+
+import (
+	"testing"
+	"time"
+)
+
+type fakeMetrics struct {
+	attempts, successes, failures int
+	backoffs                      []time.Duration
+}
+
+func (m *fakeMetrics) IncAttempt()                    { m.attempts++ }
+func (m *fakeMetrics) IncSuccess()                    { m.successes++ }
+func (m *fakeMetrics) IncFailure()                    { m.failures++ }
+func (m *fakeMetrics) ObserveBackoff(d time.Duration) { m.backoffs = append(m.backoffs, d) }
+
+func TestRetrierWithMetrics(t *testing.T) {
+	m := &fakeMetrics{}
+	r := New([]time.Duration{time.Millisecond, 2 * time.Millisecond}, nil).WithMetrics(m)
+
+	err := r.Run(genWork([]error{errFoo, errFoo}))
+	if err != nil {
+		t.Error(err)
+	}
+	if m.attempts != 3 || m.successes != 1 || m.failures != 0 {
+		t.Error("incorrect counts", m)
+	}
+	if len(m.backoffs) != 2 || m.backoffs[0] != time.Millisecond || m.backoffs[1] != 2*time.Millisecond {
+		t.Error("incorrect backoffs", m.backoffs)
+	}
+
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	if m.attempts != 6 || m.successes != 1 || m.failures != 1 {
+		t.Error("incorrect counts", m)
+	}
+
+	// the default discards everything
+	r.WithMetrics(nil)
+	if err := r.Run(genWork(nil)); err != nil {
+		t.Error(err)
+	}
+	if m.attempts != 6 {
+		t.Error("incorrect counts", m)
+	}
+}
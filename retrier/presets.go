@@ -0,0 +1,26 @@
+package retrier
+
+import (
+	"math/rand"
+	"time"
+)
+
+// PresetAWSEqualJitter constructs a Retrier (with the default classifier) which makes up to maxAttempts attempts
+// in total, backing off with the "equal jitter" strategy described by AWS: before retry n (counting from 0) it
+// waits temp/2 plus a random amount between 0 and temp/2, where temp is the smaller of maxSleep and base*2^n. This
+// keeps some back-off on every retry while still spreading out retries from many clients.
+func PresetAWSEqualJitter(maxAttempts int, base, maxSleep time.Duration) *Retrier {
+	retries := maxAttempts - 1
+	if retries < 0 {
+		retries = 0
+	}
+	r := New(LimitedExponentialBackoff(retries, base, maxSleep), nil)
+	r.jitterFn = equalJitter
+	return r
+}
+
+// equalJitter returns half of the base amount plus a random amount up to the other half.
+func equalJitter(base time.Duration, r *rand.Rand) time.Duration {
+	half := base / 2
+	return half + time.Duration(r.Float64()*float64(base-half))
+}
@@ -0,0 +1,38 @@
+package retrier
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+)
+
+func TestPresetAWSEqualJitter(t *testing.T) {
+	r := PresetAWSEqualJitter(6, 100*time.Millisecond, time.Second).WithRand(rand.New(rand.NewSource(42)))
+
+	temps := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 400 * time.Millisecond,
+		800 * time.Millisecond, time.Second}
+	if sleeps := r.DryRun(10); len(sleeps) != len(temps) {
+		t.Fatal("wrong number of retries", sleeps)
+	}
+
+	const samples = 10000
+	for n, temp := range temps {
+		var total time.Duration
+		for i := 0; i < samples; i++ {
+			sleep := r.calcSleep(n)
+			if sleep < temp/2 || sleep > temp {
+				t.Fatal("sleep", n, "out of bounds:", sleep)
+			}
+			total += sleep
+		}
+		// the sleeps are uniformly distributed between temp/2 and temp, so average 3/4 of temp
+		mean := total / samples
+		if expected := temp * 3 / 4; mean < expected*95/100 || mean > expected*105/100 {
+			t.Error("sleep", n, "has wrong mean:", mean)
+		}
+	}
+
+	if sleeps := PresetAWSEqualJitter(1, time.Second, time.Second).DryRun(10); len(sleeps) != 0 {
+		t.Error("single attempt preset retried", sleeps)
+	}
+}
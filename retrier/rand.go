@@ -0,0 +1,40 @@
+package retrier
+
+import (
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// callSeq distinguishes the seeds of per-call sources of randomness created in the same nanosecond.
+var callSeq atomic.Uint64
+
+// newCallRand returns a new source of randomness for a single run, as configured by WithPerCallRand. Seeding
+// the standard rand.Source is comparatively slow, so this uses a splitMix64 instead.
+func newCallRand() *rand.Rand {
+	seed := uint64(time.Now().UnixNano()) ^ callSeq.Add(1)*splitMixGamma
+	return rand.New(&splitMix64{state: seed})
+}
+
+const splitMixGamma = 0x9e3779b97f4a7c15
+
+// splitMix64 is a tiny, fast rand.Source64 which is cheap to seed; it is not safe for concurrent use.
+type splitMix64 struct {
+	state uint64
+}
+
+func (s *splitMix64) Seed(seed int64) {
+	s.state = uint64(seed)
+}
+
+func (s *splitMix64) Uint64() uint64 {
+	s.state += splitMixGamma
+	z := s.state
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func (s *splitMix64) Int63() int64 {
+	return int64(s.Uint64() >> 1)
+}
@@ -0,0 +1,33 @@
+package retrier
+
+import "errors"
+
+// ErrRetryIfExhausted is the error returned by RunWithResultRetryIf when all retries have been used up and
+// the work function's result still matched the retry condition.
+var ErrRetryIfExhausted = errors.New("retries exhausted while result still matched retry condition")
+
+// RunWithResultRetryIf executes the given work function using the retrier, like Run, but also retries
+// (according to the retrier's back-off policy, regardless of its classifier) whenever the work function
+// succeeds with a result for which retryIf returns true. This supports APIs which signal "try again" with a
+// successful response, such as one with a "pending" status. If all retries are used up while the result
+// still matches, the last result is returned along with ErrRetryIfExhausted. Otherwise, the last result and
+// error from the work function are returned as with Run.
+//
+// Since it is easy to write a retryIf predicate which never stops matching, RunWithResultRetryIf panics if
+// the retrier retries infinitely without also being bounded by WithMaxAttempts or WithMaxElapsedTime.
+func RunWithResultRetryIf[T any](r *Retrier, work func() (T, error), retryIf func(T) bool) (T, error) {
+	if r.isUnbounded() {
+		panic("retrier: RunWithResultRetryIf requires an infinite retrier to set WithMaxAttempts or WithMaxElapsedTime")
+	}
+
+	var result T
+	err := r.Run(func() error {
+		var err error
+		result, err = work()
+		if err == nil && retryIf(result) {
+			return ErrRetryIfExhausted
+		}
+		return err
+	})
+	return result, err
+}
@@ -0,0 +1,94 @@
+package retrier
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func genStatusWork(statuses []string) func() (string, error) {
+	i = 0
+	return func() (string, error) {
+		i++
+		if i > len(statuses) {
+			return "done", nil
+		}
+		return statuses[i-1], nil
+	}
+}
+
+func isPending(status string) bool {
+	return status == "pending"
+}
+
+func TestRunWithResultRetryIf(t *testing.T) {
+	r := New([]time.Duration{0, 0, 0}, WhitelistClassifier{errFoo})
+
+	status, err := RunWithResultRetryIf(r, genStatusWork([]string{"pending", "pending"}), isPending)
+	if err != nil {
+		t.Error(err)
+	}
+	if status != "done" {
+		t.Error("incorrect result", status)
+	}
+	if i != 3 {
+		t.Error("run wrong number of times")
+	}
+
+	status, err = RunWithResultRetryIf(r, genStatusWork([]string{"pending", "pending", "pending", "pending"}), isPending)
+	if err != ErrRetryIfExhausted {
+		t.Error(err)
+	}
+	if status != "pending" {
+		t.Error("incorrect result", status)
+	}
+	if i != 4 {
+		t.Error("run wrong number of times")
+	}
+
+	// errors are still classified as usual
+	i = 0
+	_, err = RunWithResultRetryIf(r, func() (string, error) {
+		i++
+		return "", errBar
+	}, isPending)
+	if err != errBar {
+		t.Error(err)
+	}
+	if i != 1 {
+		t.Error("run wrong number of times")
+	}
+
+	r.WithExhaustionError()
+	_, err = RunWithResultRetryIf(r, genStatusWork([]string{"pending", "pending", "pending", "pending"}), isPending)
+	if !errors.Is(err, ErrRetryIfExhausted) {
+		t.Error(err)
+	}
+}
+
+func TestRunWithResultRetryIfUnbounded(t *testing.T) {
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("unbounded infinite retrier did not panic")
+			}
+		}()
+		r := New([]time.Duration{0}, nil).WithInfiniteRetry()
+		_, _ = RunWithResultRetryIf(r, genStatusWork(nil), isPending)
+	}()
+
+	r := New([]time.Duration{0}, nil).WithInfiniteRetry().WithMaxAttempts(5)
+	status, err := RunWithResultRetryIf(r, genStatusWork([]string{"pending", "pending", "pending", "pending", "pending"}), isPending)
+	if err != ErrRetryIfExhausted || status != "pending" {
+		t.Error(status, err)
+	}
+	if i != 5 {
+		t.Error("run wrong number of times")
+	}
+
+	r = New([]time.Duration{time.Millisecond}, nil).WithInfiniteRetry().WithMaxElapsedTime(20 * time.Millisecond)
+	_, err = RunWithResultRetryIf(r, func() (string, error) { return "pending", nil }, isPending)
+	if err != ErrRetryIfExhausted {
+		t.Error(err)
+	}
+}
@@ -4,7 +4,9 @@ package retrier
 import (
 	"context"
 	"errors"
+	"log/slog"
 	"math/rand"
+	"runtime/debug"
 	"sync"
 	"time"
 )
@@ -15,10 +17,44 @@ type Retrier struct {
 	backoff           []time.Duration
 	infiniteRetry     bool
 	surfaceWorkErrors bool
+	exhaustionError   bool
+	onSuccess         func(attempts int, totalElapsed time.Duration)
+	onClassifierFail  func(err error, attempt int)
+	classObserver     func(action Action, err error)
+	validator         func() error
+	cleanup           func(ctx context.Context, attempt int, err error) error
+	gate              *Gate
+	finalAttempt      func(ctx context.Context, lastErr error) error
+	recoverIf         func(recovered any) bool
+	pacedAttempts     bool
+	concurrency       chan struct{}
+	logger            *slog.Logger
+	maxAttempts       int
+	notify            func(err error, attempt int, backoff time.Duration)
+	attemptTimeouts   []time.Duration
+	maxElapsedTime    time.Duration
+	deadlineReserve   time.Duration
+	smartErrors       bool
+	classifyNil       bool
+	contextValues     map[any]any
+	idempotencyKey    func() string
+	firstDelay        bool
+	defaultInterval   time.Duration
+	hasDefault        bool
+	metrics           Metrics
+	clock             Clock
 	class             Classifier
 	jitter            float64
+	absoluteJitter    time.Duration
+	bucket            time.Duration
+	jitterBounds      []time.Duration
+	jitterFn          func(base time.Duration, r *rand.Rand) time.Duration
+	perCallRand       bool
 	rand              *rand.Rand
 	randMu            sync.Mutex
+	strategy          Strategy
+	shared            map[string]*sharedRun
+	sharedMu          sync.Mutex
 }
 
 // New constructs a Retrier with the given backoff pattern and classifier. The length of the backoff pattern
@@ -33,18 +69,96 @@ func New(backoff []time.Duration, class Classifier) *Retrier {
 	return &Retrier{
 		backoff: backoff,
 		class:   class,
+		metrics: NoopMetrics{},
+		clock:   realClock{},
 		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
 	}
 }
 
+// NewWithStrategy constructs a Retrier which uses the given Strategy to decide how long to back off before each
+// retry (and when to stop retrying), instead of a fixed back-off pattern. The classifier is used as with New.
+// Jitter is applied to the strategy's back-offs as usual, but the options which refer to the entries of a
+// back-off pattern (WithFirstAttemptDelay, WithDefaultInterval and SetJitterBounds) have no effect.
+func NewWithStrategy(strategy Strategy, class Classifier) *Retrier {
+	r := New(nil, class)
+	r.strategy = strategy
+	return r
+}
+
+// Clone returns a deep copy of the retrier which can be safely customized (e.g. with different jitter) without
+// affecting the original. The With* and Set* methods mutate the retrier in place and are not safe to call on
+// a retrier which is concurrently being used or shared between goroutines, so clone a shared retrier first.
+// The clone gets its own source of randomness, but shares any limit set by WithMaxConcurrent.
+func (r *Retrier) Clone() *Retrier {
+	clone := &Retrier{
+		backoff:           make([]time.Duration, len(r.backoff)),
+		infiniteRetry:     r.infiniteRetry,
+		surfaceWorkErrors: r.surfaceWorkErrors,
+		exhaustionError:   r.exhaustionError,
+		onSuccess:         r.onSuccess,
+		onClassifierFail:  r.onClassifierFail,
+		classObserver:     r.classObserver,
+		validator:         r.validator,
+		cleanup:           r.cleanup,
+		gate:              r.gate,
+		finalAttempt:      r.finalAttempt,
+		recoverIf:         r.recoverIf,
+		pacedAttempts:     r.pacedAttempts,
+		concurrency:       r.concurrency,
+		logger:            r.logger,
+		maxAttempts:       r.maxAttempts,
+		notify:            r.notify,
+		maxElapsedTime:    r.maxElapsedTime,
+		deadlineReserve:   r.deadlineReserve,
+		smartErrors:       r.smartErrors,
+		classifyNil:       r.classifyNil,
+		contextValues:     r.contextValues,
+		idempotencyKey:    r.idempotencyKey,
+		firstDelay:        r.firstDelay,
+		defaultInterval:   r.defaultInterval,
+		hasDefault:        r.hasDefault,
+		metrics:           r.metrics,
+		clock:             r.clock,
+		class:             r.class,
+		jitter:            r.jitter,
+		absoluteJitter:    r.absoluteJitter,
+		bucket:            r.bucket,
+		jitterFn:          r.jitterFn,
+		perCallRand:       r.perCallRand,
+		strategy:          r.strategy,
+		rand:              rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+	copy(clone.backoff, r.backoff)
+	if r.jitterBounds != nil {
+		clone.jitterBounds = make([]time.Duration, len(r.jitterBounds))
+		copy(clone.jitterBounds, r.jitterBounds)
+	}
+	if r.attemptTimeouts != nil {
+		clone.attemptTimeouts = make([]time.Duration, len(r.attemptTimeouts))
+		copy(clone.attemptTimeouts, r.attemptTimeouts)
+	}
+	return clone
+}
+
 // WithInfiniteRetry set the retrier to loop infinitely on the last backoff duration. Using this option,
-// the program will not exit until the retried function has been executed successfully.
+// the program will not exit until the retried function has been executed successfully. If the backoff
+// slice is empty, the function is retried immediately with no back-off at all; use WithDefaultInterval
+// to avoid such a tight loop.
 // WARNING : This may run indefinitely.
 func (r *Retrier) WithInfiniteRetry() *Retrier {
 	r.infiniteRetry = true
 	return r
 }
 
+// WithDefaultInterval configures the back-off to use once the backoff slice runs out (which only happens when
+// retrying beyond its length with WithInfiniteRetry or WithMaxAttempts), instead of repeating its last entry
+// or, if the slice is empty, not backing off at all. Jitter is applied to it as to any other back-off.
+func (r *Retrier) WithDefaultInterval(d time.Duration) *Retrier {
+	r.defaultInterval = d
+	r.hasDefault = true
+	return r
+}
+
 // WithSurfaceWorkErrors configures the retrier to always return the last error received from work function
 // even if a context timeout/deadline is hit.
 func (r *Retrier) WithSurfaceWorkErrors() *Retrier {
@@ -52,6 +166,323 @@ func (r *Retrier) WithSurfaceWorkErrors() *Retrier {
 	return r
 }
 
+// WithExhaustionError configures the retrier to return an *ExhaustedError wrapping the last error from the
+// work function when all retries have been used up, so that callers can distinguish running out of retries
+// from other failures. The ExhaustedError unwraps to the work function's error.
+func (r *Retrier) WithExhaustionError() *Retrier {
+	r.exhaustionError = true
+	return r
+}
+
+// WithOnSuccess configures the retrier to call the given function exactly once whenever a run eventually
+// succeeds (returns nil), passing the total number of times the work function was executed (1 meaning it
+// succeeded on the first try) and the total time elapsed, including back-offs. This includes runs which
+// exhausted their retries but were recovered by the function given to WithFinalAttempt.
+func (r *Retrier) WithOnSuccess(fn func(attempts int, totalElapsed time.Duration)) *Retrier {
+	r.onSuccess = fn
+	return r
+}
+
+// WithOnClassifierFail configures the retrier to call the given function whenever a run stops early because
+// the classifier returned Fail, passing the error and the number of the attempt which produced it (1 meaning
+// the first). It is not called when a run succeeds or is stopped because its retries are exhausted, which
+// allows non-retryable errors to be told apart from those which simply never went away.
+func (r *Retrier) WithOnClassifierFail(fn func(err error, attempt int)) *Retrier {
+	r.onClassifierFail = fn
+	return r
+}
+
+// WithClassifierObserver configures the retrier to call the given function with the action and error every
+// time an attempt's result is classified, including successful results. Counting the actions is useful when
+// tuning a classifier: for example, if most errors are classified as Fail then retrying rarely helps. With
+// RunHedgedCtx the function may be called concurrently.
+func (r *Retrier) WithClassifierObserver(fn func(action Action, err error)) *Retrier {
+	r.classObserver = fn
+	return r
+}
+
+// WithResultValidator configures the retrier to call the given function after every attempt in which the work
+// function returns nil. If it returns an error, the attempt is treated exactly as if the work function had
+// returned that error instead (so it is classified, and may be retried). This supports work whose result is
+// some external state rather than a return value; see RunWithResultRetryIf for work which returns a result.
+func (r *Retrier) WithResultValidator(validator func() error) *Retrier {
+	r.validator = validator
+	return r
+}
+
+// WithCleanup configures the retrier to call the given function after every failed attempt which is going to be
+// retried, before backing off, passing the error and the number of the attempt which produced it (1 meaning the
+// first). This allows any side effects of the failed attempt to be undone, e.g. by rolling back a transaction.
+// Unlike the function passed to WithNotify, it can abort the run: if it returns an error, no more attempts are
+// made and that error is returned. It is not called after the final attempt of a run.
+func (r *Retrier) WithCleanup(cleanup func(ctx context.Context, attempt int, err error) error) *Retrier {
+	r.cleanup = cleanup
+	return r
+}
+
+// WithGate configures the retrier to wait before every attempt for as long as the given gate is paused (or
+// until the context is done, in which case the context's error is returned). The same gate can be shared by
+// any number of retriers.
+func (r *Retrier) WithGate(gate *Gate) *Retrier {
+	r.gate = gate
+	return r
+}
+
+// WithRecoverIf configures the retrier to recover panics in the work function, calling the given function
+// with each recovered value. If it returns true, the attempt is treated as having failed with a *PanicError
+// wrapping the value, which is always retried (whatever the classifier says) and is returned if the retries
+// are exhausted. If it returns false, the value is re-panicked immediately, with the stack of the original
+// panic still shown in the resulting trace. By default panics are not recovered at all.
+func (r *Retrier) WithRecoverIf(fn func(recovered any) (retry bool)) *Retrier {
+	r.recoverIf = fn
+	return r
+}
+
+// WithFinalAttempt configures the retrier to call the given function when a run is still failing after all
+// retries are used up, passing the last error; whatever it returns is then returned from the run instead
+// (even with WithExhaustionError). This allows a run to degrade gracefully, e.g. by falling back to cached
+// data. It is not called for runs which stop because the classifier returned Fail or the context is done. If it
+// returns nil, the run counts as a success, including for WithOnSuccess and Metrics.
+func (r *Retrier) WithFinalAttempt(fn func(ctx context.Context, lastErr error) error) *Retrier {
+	r.finalAttempt = fn
+	return r
+}
+
+// WithMaxConcurrent limits the number of runs (including all of their retries and back-offs) which may be
+// executing at once across all goroutines using this retrier. Excess callers block before their first attempt
+// until another run finishes, or until their context is done in which case the context's error is returned
+// without ever executing the work function. This sheds load when a shared retrier is hammered during an
+// incident. Values of n less than 1 are silently ignored.
+func (r *Retrier) WithMaxConcurrent(n int) *Retrier {
+	if n < 1 {
+		return r
+	}
+	r.concurrency = make(chan struct{}, n)
+	return r
+}
+
+// WithMaxAttempts limits the total number of times the work function is executed in a single run to n, even
+// if the back-off pattern is longer or the retrier is set to retry infinitely. Values of n less than 1 are
+// silently ignored.
+func (r *Retrier) WithMaxAttempts(n int) *Retrier {
+	if n < 1 {
+		return r
+	}
+	r.maxAttempts = n
+	return r
+}
+
+// WithDeadlineReserve configures the retrier to keep the last d of the context's deadline (if it has one) for the
+// caller, e.g. to clean up after the retries fail. The work function's context is given a deadline d earlier,
+// and once the next back-off would end within d of the original deadline, the retrier stops retrying and treats
+// the run as exhausted, returning the last error with at least d still remaining. If less than d remains when
+// the run starts, the work function is passed a context which is already done. Values of d less than or equal
+// to 0 are silently ignored.
+func (r *Retrier) WithDeadlineReserve(d time.Duration) *Retrier {
+	if d <= 0 {
+		return r
+	}
+	r.deadlineReserve = d
+	return r
+}
+
+// WithMaxElapsedTime limits the total time spent in a single run: once the time elapsed since the run started
+// plus the next back-off would exceed d, the retrier stops retrying and treats the run as exhausted. Values of
+// d less than or equal to 0 are silently ignored.
+func (r *Retrier) WithMaxElapsedTime(d time.Duration) *Retrier {
+	if d <= 0 {
+		return r
+	}
+	r.maxElapsedTime = d
+	return r
+}
+
+// WithNotify configures the retrier to call the given function every time the work function fails with a
+// retriable error and is about to be retried, passing the error, the attempt number that failed (starting
+// at 1), and the back-off that will be waited before the next attempt.
+func (r *Retrier) WithNotify(fn func(err error, attempt int, backoff time.Duration)) *Retrier {
+	r.notify = fn
+	return r
+}
+
+// WithAttemptTimeouts configures the retrier to give each attempt its own timeout: the context passed to the
+// work function for attempt k (starting at 0) is cancelled after timeouts[k], with the last value repeating for
+// any further attempts. This lets later attempts be given more time than earlier ones. It only has an effect
+// with RunCtx and RunFn, when the work function respects its context. An empty slice disables the timeouts.
+func (r *Retrier) WithAttemptTimeouts(timeouts []time.Duration) *Retrier {
+	r.attemptTimeouts = timeouts
+	return r
+}
+
+// WithEvenlyPacedAttempts configures the retrier to give each attempt a timeout of the time remaining from the
+// budget set by WithMaxElapsedTime, divided by the number of attempts remaining (including that one), so that
+// a single slow attempt cannot use up the entire budget. The timeouts adapt as the run proceeds: an attempt
+// which finishes early leaves more time for the others, while back-offs leave less. For a retrier which retries
+// infinitely without WithMaxAttempts, each attempt is given all of the remaining time. If WithAttemptTimeouts
+// is also set, the smaller of the two timeouts applies. As with WithAttemptTimeouts, it only has an effect with
+// RunCtx and RunFn, when the work function respects its context, and it has no effect without WithMaxElapsedTime.
+func (r *Retrier) WithEvenlyPacedAttempts() *Retrier {
+	r.pacedAttempts = true
+	return r
+}
+
+// WithContextValues configures the retrier to add the given key/value pairs to the context passed to the work
+// function on every attempt, as with context.WithValue. This is handy for attaching metadata such as an
+// operation name for downstream tracing or logging. The map is copied, so later changes to it have no effect.
+func (r *Retrier) WithContextValues(values map[any]any) *Retrier {
+	r.contextValues = make(map[any]any, len(values))
+	for k, v := range values {
+		r.contextValues[k] = v
+	}
+	return r
+}
+
+// WithIdempotencyKey configures the retrier to call gen once at the start of each run, and add the key it
+// returns to the context passed to every attempt of that run, where IdempotencyKeyFromContext retrieves it.
+// Sending the key with a mutating request lets the server recognise retries of an operation it already
+// performed, since the key is the same for every attempt of a run but differs between runs.
+func (r *Retrier) WithIdempotencyKey(gen func() string) *Retrier {
+	r.idempotencyKey = gen
+	return r
+}
+
+// WithFirstAttemptDelay makes explicit whether the first back-off is waited before the very first attempt.
+// When false (the default), the first attempt is immediate and backoff[i] is waited before attempt i+1 (so
+// backoff[0] is waited before the first retry). When true, backoff[0] (with jitter) is additionally waited
+// before the first attempt, and the rest of the schedule is unchanged.
+func (r *Retrier) WithFirstAttemptDelay(delay bool) *Retrier {
+	r.firstDelay = delay
+	return r
+}
+
+// WithMetrics configures the retrier to report to the given Metrics implementation. Passing nil restores the
+// default, which discards everything.
+func (r *Retrier) WithMetrics(metrics Metrics) *Retrier {
+	if metrics == nil {
+		metrics = NoopMetrics{}
+	}
+	r.metrics = metrics
+	return r
+}
+
+// WithClock configures the retrier to use the given Clock, instead of the real one, to measure elapsed time
+// (for WithMaxElapsedTime, the history and callbacks) and to wait between attempts. This is intended for
+// tests; see the retriertest package. Passing nil restores the real clock.
+func (r *Retrier) WithClock(clock Clock) *Retrier {
+	if clock == nil {
+		clock = realClock{}
+	}
+	r.clock = clock
+	return r
+}
+
+// WithClassifyNil configures whether the classifier is consulted when the work function returns nil. By
+// default it is not, and nil is always treated as Succeed. When enabled, a classifier which returns Retry for
+// nil can be used to keep retrying until some condition (checked by the classifier) is met; if the retries
+// are exhausted first, the run returns nil (or an ExhaustedError wrapping nil, with WithExhaustionError).
+//
+// Since such a classifier can easily retry forever, running a retrier with this enabled panics if it retries
+// infinitely without also being bounded by WithMaxAttempts or WithMaxElapsedTime.
+func (r *Retrier) WithClassifyNil(enabled bool) *Retrier {
+	r.classifyNil = enabled
+	return r
+}
+
+// WithLogger configures the retrier to emit structured logs to the given logger: a debug-level record for every
+// retry (with the attempt number, the error, and the back-off before the next attempt) and a warn-level record
+// when retries are exhausted. By default, nothing is logged.
+func (r *Retrier) WithLogger(logger *slog.Logger) *Retrier {
+	r.logger = logger
+	return r
+}
+
+// WithSmartErrorAggregation configures the retrier, when a run ends in failure (either because the classifier
+// returned Fail or because retries were exhausted), to return an error covering every attempt rather than
+// just the last one. If every attempt failed with the same error (as determined by errors.Is), that single
+// error is returned; otherwise the distinct errors are combined with errors.Join, in the order they were
+// first seen. This keeps messages concise while preserving detail when the failures differ.
+func (r *Retrier) WithSmartErrorAggregation() *Retrier {
+	r.smartErrors = true
+	return r
+}
+
+// WithRand configures the retrier to use the given source of randomness for jitter, instead of one seeded
+// from the current time. Using a rand.Rand with a fixed seed makes the applied jitter deterministic.
+// The retrier serializes its own use of rnd, but rnd must not be used by anything else concurrently.
+func (r *Retrier) WithRand(rnd *rand.Rand) *Retrier {
+	r.randMu.Lock()
+	defer r.randMu.Unlock()
+	r.rand = rnd
+	return r
+}
+
+// WithPerCallRand configures the retrier to give each run its own source of randomness for jitter, freshly
+// (and cheaply) seeded from the current time and a process-wide counter, instead of sharing one (behind a lock)
+// between all runs. This avoids lock contention when many goroutines run the same retrier at once, at the cost of
+// a small allocation per run; any source set with WithRand is then only used by DryRun.
+func (r *Retrier) WithPerCallRand() *Retrier {
+	r.perCallRand = true
+	return r
+}
+
+// WithBucketAlignment configures the retrier to extend each back-off (after any other jitter) so that it ends on
+// the next multiple of bucket on the wall clock, measured from the Unix epoch; e.g. with a bucket of 5s, retries
+// only happen at 5-second boundaries. This lets independent clients coordinate their retries without talking
+// to each other. Any jitter set WithAbsoluteJitter is then added after the boundary instead, as a random offset
+// in the range [0, d), to spread out the retries within the bucket. Back-offs hinted by errors or classifiers
+// are not aligned. Values of bucket less than or equal to 0 disable the alignment.
+func (r *Retrier) WithBucketAlignment(bucket time.Duration) *Retrier {
+	r.bucket = bucket
+	return r
+}
+
+// WithAbsoluteJitter configures the retrier to add a uniformly random amount in the range (-d, +d) to each
+// back-off, on top of any jitter set with SetJitter. The resulting back-off is clamped to be non-negative.
+// Unlike SetJitter, which scales with the back-off, this spreads out retries even when the back-off is zero,
+// avoiding a thundering herd of clients all retrying at the same instant. Negative values are silently ignored.
+func (r *Retrier) WithAbsoluteJitter(d time.Duration) *Retrier {
+	if d < 0 {
+		return r
+	}
+	r.absoluteJitter = d
+	return r
+}
+
+// WithJitterDistribution configures the retrier to apply jitter by calling the given function with each base
+// back-off (the next entry of the back-off pattern) and the retrier's source of randomness (see WithRand),
+// using whatever it returns (clamped to be non-negative) instead. This replaces the proportional jitter set with
+// SetJitter, which is uniformly distributed, allowing e.g. a distribution weighted towards shorter back-offs;
+// any absolute jitter or jitter bounds are still applied on top. The function is called with the retrier's
+// internal lock held, so it must not call back into the retrier. Passing nil restores the default.
+func (r *Retrier) WithJitterDistribution(fn func(base time.Duration, r *rand.Rand) time.Duration) *Retrier {
+	r.jitterFn = fn
+	return r
+}
+
+// DryRun returns the back-offs which a run would wait for if the work function failed with a retriable error
+// the given number of times, without executing anything. Jitter is applied using the retrier's source of
+// randomness (so this consumes random values, exactly as a real run would), the result is cut short where
+// the retrier would give up, and WithMaxElapsedTime is applied as if the work itself took no time. This is
+// useful for tuning and validating back-off and jitter configuration.
+func (r *Retrier) DryRun(attempts int) []time.Duration {
+	var sleeps []time.Duration
+	var elapsed, prev time.Duration
+	start := r.clock.Now()
+	for retries := 0; retries < attempts && !r.isExhausted(retries); retries++ {
+		backoff, ok := r.nextBackoff(context.Background(), retries, prev, nil)
+		if ok {
+			backoff = r.align(start.Add(elapsed), backoff, nil)
+		}
+		if !ok || (r.maxElapsedTime > 0 && elapsed+backoff > r.maxElapsedTime) {
+			break
+		}
+		elapsed += backoff
+		sleeps = append(sleeps, backoff)
+		prev = backoff
+	}
+	return sleeps
+}
+
 // Run executes the given work function by executing RunCtx without context.Context.
 func (r *Retrier) Run(work func() error) error {
 	return r.RunFn(context.Background(), func(c context.Context, r int) error {
@@ -64,7 +495,8 @@ func (r *Retrier) Run(work func() error) error {
 // to construct the Retrier. If the result is Succeed or Fail, the return value of the work function is
 // returned to the caller. If the result is Retry, then Run sleeps according to the its backoff policy
 // before retrying. If the total number of retries is exceeded then the return value of the work function
-// is returned to the caller regardless.
+// is returned to the caller regardless. If the context is done while waiting to retry, its cause (see RunFn)
+// is returned.
 func (r *Retrier) RunCtx(ctx context.Context, work func(ctx context.Context) error) error {
 	return r.RunFn(ctx, func(c context.Context, r int) error {
 		return work(c)
@@ -75,31 +507,208 @@ func (r *Retrier) RunCtx(ctx context.Context, work func(ctx context.Context) err
 // to construct the Retrier. If the result is Succeed or Fail, the return value of the work function is
 // returned to the caller. If the result is Retry, then Run sleeps according to the backoff policy
 // before retrying. If the total number of retries is exceeded then the return value of the work function
-// is returned to the caller regardless. The work function takes 2 args, the context and
-// the number of attempted retries.
+// is returned to the caller regardless. The retrier only ever backs off before an attempt it is going to make,
+// so a run which ends (whether by Succeed, Fail or exhaustion) returns as soon as its final attempt does. The
+// work function takes 2 args, the context and the number of attempted retries. If the work function fails
+// with context.DeadlineExceeded (e.g. from a sub-operation with its own timeout) and the context will be done
+// before the back-off ends, that error is returned immediately, since there would be no time left to retry
+// anyway. If the context is done while waiting to retry (or for a concurrency slot or a paused Gate),
+// context.Cause(ctx) is returned: the cause given to context.WithCancelCause (or similar) if there is one, and
+// otherwise ctx.Err().
 func (r *Retrier) RunFn(ctx context.Context, work func(ctx context.Context, retries int) error) error {
+	return r.runFn(ctx, work, nil)
+}
+
+// runFn implements RunFn, also recording each attempt in the given history if it is not nil.
+func (r *Retrier) runFn(ctx context.Context, work func(ctx context.Context, retries int) error, history *History) error {
+	if r.classifyNil && r.isUnbounded() {
+		panic("retrier: WithClassifyNil requires an infinite retrier to set WithMaxAttempts or WithMaxElapsedTime")
+	}
+
+	err := r.run(ctx, work, history)
+	r.countRun(err)
+	return err
+}
+
+// countRun records the result of a run in the retrier's metrics.
+func (r *Retrier) countRun(err error) {
+	if err == nil {
+		r.metrics.IncSuccess()
+	} else {
+		r.metrics.IncFailure()
+	}
+}
+
+// begin prepares to start a run: it waits for a concurrency slot if WithMaxConcurrent is set, and derives the
+// context to pass to the work function. The returned function must be called once the run is over.
+func (r *Retrier) begin(ctx context.Context) (context.Context, func(), error) {
+	release := func() {}
+	if r.concurrency != nil {
+		select {
+		case r.concurrency <- struct{}{}:
+			release = func() { <-r.concurrency }
+		case <-ctx.Done():
+			return nil, nil, context.Cause(ctx)
+		}
+	}
+
+	for k, v := range r.contextValues {
+		ctx = context.WithValue(ctx, k, v)
+	}
+	if r.idempotencyKey != nil {
+		ctx = context.WithValue(ctx, idempotencyKeyCtx{}, r.idempotencyKey())
+	}
+	if deadline, ok := ctx.Deadline(); ok && r.deadlineReserve > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithDeadline(ctx, deadline.Add(-r.deadlineReserve))
+		slot := release
+		release = func() {
+			cancel()
+			slot()
+		}
+	}
+
+	return ctx, release, nil
+}
+
+func (r *Retrier) run(ctx context.Context, work func(ctx context.Context, retries int) error, history *History) error {
+	ctx, end, err := r.begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer end()
+
+	var rng *rand.Rand
+	if r.perCallRand {
+		rng = newCallRand()
+	}
+
+	start := r.clock.Now()
+	if r.firstDelay && len(r.backoff) > 0 {
+		backoff := r.align(start, r.jitterSleep(0, r.baseSleep(0), rng), rng)
+		r.metrics.ObserveBackoff(backoff)
+		if err := r.sleep(ctx, backoff); err != nil {
+			return err
+		}
+	}
+
 	retries := 0
+	var prev time.Duration
+	var errs []error
 	for {
-		ret := work(ctx, retries)
+		if err := r.gate.wait(ctx); err != nil {
+			return err
+		}
+
+		attemptStart := r.clock.Now()
+		ret := r.runAttempt(ctx, retries, start, work)
+		if ret == nil && r.validator != nil {
+			ret = r.validator()
+		}
+		if history != nil {
+			*history = append(*history, AttemptRecord{Index: retries, Err: ret, Start: attemptStart, End: r.clock.Now()})
+		}
+		if r.smartErrors && ret != nil {
+			errs = append(errs, ret)
+		}
 
-		switch r.class.Classify(ret) {
+		action, hinted, hasHint := r.classify(ret)
+		if recorder, ok := r.strategy.(OutcomeRecorder); ok {
+			recorder.RecordOutcome(action != Succeed)
+		}
+		switch action {
 		case Succeed, Fail:
+			if ret == nil && r.onSuccess != nil {
+				r.onSuccess(retries+1, r.clock.Now().Sub(start))
+			}
+			if action == Fail && r.onClassifierFail != nil {
+				r.onClassifierFail(ret, retries+1)
+			}
+			if action == Fail && r.smartErrors && ret != nil {
+				return aggregateErrors(errs)
+			}
 			return ret
 		case Retry:
-			if !r.infiniteRetry && retries >= len(r.backoff) {
+			if r.isExhausted(retries) {
+				if r.smartErrors {
+					ret = aggregateErrors(errs)
+				}
+				return r.exhausted(ctx, retries+1, start, ret)
+			}
+
+			next, more := r.nextBackoff(ctx, retries, prev, rng)
+			if !more {
+				if r.smartErrors {
+					ret = aggregateErrors(errs)
+				}
+				return r.exhausted(ctx, retries+1, start, ret)
+			}
+
+			backoff, ok := errorBackoff(ret)
+			if !ok {
+				if hasHint {
+					backoff = hinted
+				} else {
+					backoff = r.align(r.clock.Now(), next, rng)
+				}
+			}
+			prev = backoff
+
+			if r.maxElapsedTime > 0 && r.clock.Now().Sub(start)+backoff > r.maxElapsedTime {
+				if r.smartErrors {
+					ret = aggregateErrors(errs)
+				}
+				return r.exhausted(ctx, retries+1, start, ret)
+			}
+
+			if r.deadlineReserve > 0 && !hasTimeFor(ctx, backoff) {
+				// backing off would eat into the time reserved for the caller
+				if r.smartErrors {
+					ret = aggregateErrors(errs)
+				}
+				return r.exhausted(ctx, retries+1, start, ret)
+			}
+
+			if errors.Is(ret, context.DeadlineExceeded) && !hasTimeFor(ctx, backoff) {
+				// the work timed out, and so would its next attempt, since the context will be done before it
+				// can even start; don't waste time backing off only to return the context's error
+				if r.smartErrors {
+					ret = aggregateErrors(errs)
+				}
 				return ret
 			}
 
-			var err *errWithBackoff
-			var backoff time.Duration
-			if errors.As(ret, &err) {
-				backoff = err.backoff
-			} else {
-				backoff = r.calcSleep(retries)
+			if r.cleanup != nil {
+				if err := r.cleanup(ctx, retries+1, ret); err != nil {
+					return err
+				}
+			}
+
+			r.metrics.ObserveBackoff(backoff)
+			if history != nil {
+				(*history)[len(*history)-1].Backoff = backoff
+			}
+			if r.notify != nil {
+				r.notify(ret, retries+1, backoff)
+			}
+			if r.logger != nil {
+				r.logger.LogAttrs(ctx, slog.LevelDebug, "retrying after error",
+					slog.Int("attempt", retries+1),
+					slog.Any("error", ret),
+					slog.Duration("backoff", backoff),
+				)
 			}
 
-			timer := time.NewTimer(backoff)
-			if err := r.sleep(ctx, timer); err != nil {
+			// check the context both before and after sleeping, since if the deadline passes just as the
+			// back-off ends then the sleep may still return first, and the next attempt would be wasted
+			err := ctxDone(ctx)
+			if err == nil {
+				err = r.sleep(ctx, backoff)
+			}
+			if err == nil {
+				err = ctxDone(ctx)
+			}
+			if err != nil {
 				if r.surfaceWorkErrors {
 					return ret
 				}
@@ -111,25 +720,285 @@ func (r *Retrier) RunFn(ctx context.Context, work func(ctx context.Context, retr
 	}
 }
 
-func (r *Retrier) sleep(ctx context.Context, timer *time.Timer) error {
+// runAttempt executes a single attempt of the work function, applying any per-attempt timeout.
+func (r *Retrier) runAttempt(ctx context.Context, retries int, start time.Time, work func(ctx context.Context, retries int) error) (ret error) {
+	r.metrics.IncAttempt()
+
+	if r.recoverIf != nil {
+		defer func() {
+			if recovered := recover(); recovered != nil {
+				if !r.recoverIf(recovered) {
+					panic(recovered)
+				}
+				ret = &PanicError{Value: recovered, Stack: debug.Stack()}
+			}
+		}()
+	}
+
+	timeout, ok := r.attemptTimeout(retries, start)
+	if !ok {
+		return work(ctx, retries)
+	}
+
+	attemptCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	return work(attemptCtx, retries)
+}
+
+// attemptTimeout returns the timeout for the given attempt of a run which started at the given time, if any.
+func (r *Retrier) attemptTimeout(retries int, start time.Time) (time.Duration, bool) {
+	var timeout time.Duration
+	ok := false
+
+	if len(r.attemptTimeouts) > 0 {
+		timeout = r.attemptTimeouts[min(retries, len(r.attemptTimeouts)-1)]
+		ok = true
+	}
+
+	if r.pacedAttempts && r.maxElapsedTime > 0 {
+		paced := (r.maxElapsedTime - r.clock.Now().Sub(start)) / time.Duration(r.remainingAttempts(retries))
+		if !ok || paced < timeout {
+			timeout = paced
+			ok = true
+		}
+	}
+
+	return timeout, ok
+}
+
+// remainingAttempts returns the number of attempts a run could still make, including the given one, or 1 if
+// that is unknown because the retrier retries infinitely.
+func (r *Retrier) remainingAttempts(retries int) int {
+	total := 0
+	if !r.infiniteRetry && r.strategy == nil {
+		total = len(r.backoff) + 1
+	}
+	if r.maxAttempts > 0 && (total == 0 || r.maxAttempts < total) {
+		total = r.maxAttempts
+	}
+	if total == 0 {
+		return 1
+	}
+	return max(total-retries, 1)
+}
+
+// classify determines how to proceed after the work function returned the given value, and
+// optionally how long to back off for if the classifier is a BackoffClassifier.
+func (r *Retrier) classify(ret error) (Action, time.Duration, bool) {
+	action, backoff, ok := r.decide(ret)
+	if r.classObserver != nil {
+		r.classObserver(action, ret)
+	}
+	return action, backoff, ok
+}
+
+// decide returns the action for the given result, along with any back-off hinted by the classifier.
+func (r *Retrier) decide(ret error) (Action, time.Duration, bool) {
+	if ret == ErrRetryIfExhausted {
+		// the result was rejected by RunWithResultRetryIf, which always retries
+		return Retry, 0, false
+	}
+	if _, ok := ret.(*PanicError); ok {
+		// the panic was accepted by WithRecoverIf, which always retries
+		return Retry, 0, false
+	}
+	if ret == nil && !r.classifyNil {
+		return Succeed, 0, false
+	}
+	if class, ok := r.class.(BackoffClassifier); ok {
+		action, backoff, ok := class.ClassifyBackoff(ret)
+		return action, backoff, ok && action == Retry
+	}
+	return r.class.Classify(ret), 0, false
+}
+
+// ctxDone returns the context's cause if it is done, treating the context as done once its deadline has passed
+// even if it has not yet been cancelled (the cancellation happens asynchronously, so may lag slightly behind the
+// deadline).
+func ctxDone(ctx context.Context) error {
+	if deadline, ok := ctx.Deadline(); ok && !time.Now().Before(deadline) {
+		<-ctx.Done()
+	}
+	return context.Cause(ctx)
+}
+
+// hasTimeFor reports whether the context will still not be done after the given back-off.
+func hasTimeFor(ctx context.Context, backoff time.Duration) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	deadline, ok := ctx.Deadline()
+	return !ok || time.Until(deadline) > backoff
+}
+
+// errorBackoff returns the back-off hinted by the given error, if any.
+func errorBackoff(err error) (time.Duration, bool) {
+	var hinter BackoffHinter
+	if errors.As(err, &hinter) {
+		return hinter.RetryBackoff()
+	}
+	return 0, false
+}
+
+// isExhausted reports whether there are no retries left after the given number of retries.
+func (r *Retrier) isExhausted(retries int) bool {
+	if r.maxAttempts > 0 && retries+1 >= r.maxAttempts {
+		return true
+	}
+	if r.strategy != nil {
+		// the strategy decides when it is asked for the next back-off
+		return false
+	}
+	return !r.infiniteRetry && retries >= len(r.backoff)
+}
+
+// isUnbounded reports whether the retrier could retry forever.
+func (r *Retrier) isUnbounded() bool {
+	return r.infiniteRetry && r.maxAttempts == 0 && r.maxElapsedTime == 0
+}
+
+// exhausted returns the error for a run started at the given time which is still failing after all retries are
+// used up. If that error is nil (e.g. because the final attempt recovered), the run counts as a success.
+func (r *Retrier) exhausted(ctx context.Context, attempts int, start time.Time, ret error) error {
+	if r.logger != nil {
+		r.logger.LogAttrs(ctx, slog.LevelWarn, "retries exhausted",
+			slog.Int("attempts", attempts),
+			slog.Any("error", ret),
+		)
+	}
+
+	switch {
+	case r.finalAttempt != nil:
+		ret = r.finalAttempt(ctx, ret)
+	case r.exhaustionError:
+		return &ExhaustedError{Attempts: attempts, Last: ret}
+	}
+	if ret == nil && r.onSuccess != nil {
+		r.onSuccess(attempts, r.clock.Now().Sub(start))
+	}
+	return ret
+}
+
+func (r *Retrier) sleep(ctx context.Context, d time.Duration) error {
+	timer := r.clock.NewTimer(d)
 	select {
-	case <-timer.C:
+	case <-timer.C():
 		return nil
 	case <-ctx.Done():
 		timer.Stop()
-		return ctx.Err()
+		return context.Cause(ctx)
+	}
+}
+
+// baseSleep returns the back-off before retry i, without any jitter. Once the backoff slice runs out, the
+// default interval is used if one is set, or else the last entry is repeated (or 0 if there are none).
+func (r *Retrier) baseSleep(i int) time.Duration {
+	switch {
+	case i < len(r.backoff):
+		return r.backoff[i]
+	case r.hasDefault:
+		return r.defaultInterval
+	case len(r.backoff) == 0:
+		return 0
+	default:
+		return r.backoff[len(r.backoff)-1]
+	}
+}
+
+// nextBackoff returns the back-off before retry i (including jitter) given the previous back-off, or false if
+// the retrier's Strategy says not to retry again. Jitter uses rng, or the retrier's shared source if nil.
+func (r *Retrier) nextBackoff(ctx context.Context, i int, prev time.Duration, rng *rand.Rand) (time.Duration, bool) {
+	if r.strategy == nil {
+		return r.jitterSleep(i, r.baseSleep(i), rng), true
 	}
+	base, ok := StrategyWithContext(r.strategy).NextCtx(ctx, i, prev)
+	if !ok {
+		return 0, false
+	}
+	return r.jitterSleep(i, base, rng), true
 }
 
 func (r *Retrier) calcSleep(i int) time.Duration {
-	if i >= len(r.backoff) {
-		i = len(r.backoff) - 1
+	return r.jitterSleep(i, r.baseSleep(i), nil)
+}
+
+// jitterSleep applies all the configured jitter to the given base back-off before retry i, using rng as the
+// source of randomness, or the retrier's shared source if rng is nil.
+func (r *Retrier) jitterSleep(i int, base time.Duration, rng *rand.Rand) time.Duration {
+	if rng == nil {
+		// lock unsafe rand prng
+		r.randMu.Lock()
+		defer r.randMu.Unlock()
+		rng = r.rand
 	}
-	// lock unsafe rand prng
-	r.randMu.Lock()
-	defer r.randMu.Unlock()
-	// take a random float in the range (-r.jitter, +r.jitter) and multiply it by the base amount
-	return r.backoff[i] + time.Duration(((r.rand.Float64()*2)-1)*r.jitter*float64(r.backoff[i]))
+	var sleep time.Duration
+	if r.jitterFn != nil {
+		sleep = max(r.jitterFn(base, rng), 0)
+	} else {
+		// take a random float in the range (-r.jitter, +r.jitter) and multiply it by the base amount
+		sleep = base + time.Duration(((rng.Float64()*2)-1)*r.jitter*float64(base))
+	}
+	if r.absoluteJitter > 0 && r.bucket <= 0 {
+		// then add a random amount in the range (-r.absoluteJitter, +r.absoluteJitter)
+		sleep += time.Duration(((rng.Float64() * 2) - 1) * float64(r.absoluteJitter))
+		if sleep < 0 {
+			sleep = 0
+		}
+	}
+	if len(r.jitterBounds) > 0 {
+		// then add a random amount within this step's own bounds
+		bound := r.jitterBounds[min(i, len(r.jitterBounds)-1)]
+		sleep += time.Duration(((rng.Float64() * 2) - 1) * float64(bound))
+		if sleep < 0 {
+			sleep = 0
+		}
+	}
+	return sleep
+}
+
+// align extends a back-off starting at now so that it ends on the next bucket boundary, if configured
+// WithBucketAlignment, then adds any absolute jitter as an offset past the boundary.
+func (r *Retrier) align(now time.Time, sleep time.Duration, rng *rand.Rand) time.Duration {
+	if r.bucket <= 0 {
+		return sleep
+	}
+	if offset := time.Duration(now.Add(sleep).UnixNano() % int64(r.bucket)); offset > 0 {
+		sleep += r.bucket - offset
+	}
+	if r.absoluteJitter > 0 {
+		if rng == nil {
+			// lock unsafe rand prng
+			r.randMu.Lock()
+			defer r.randMu.Unlock()
+			rng = r.rand
+		}
+		sleep += time.Duration(rng.Float64() * float64(r.absoluteJitter))
+	}
+	return sleep
+}
+
+// SetJitterBounds sets an absolute amount of jitter for each step of the back-off pattern: the back-off before
+// retry k is adjusted by a random amount in the range (-bounds[k], +bounds[k]), and never drops below 0. This is
+// applied in addition to any other jitter, and the last bound is reused once the backoff slice runs out. It
+// returns ErrInvalidJitterBounds (leaving the retrier unchanged) unless there is exactly one non-negative
+// bound per back-off. Passing nil removes any bounds.
+func (r *Retrier) SetJitterBounds(bounds []time.Duration) error {
+	if bounds == nil {
+		r.jitterBounds = nil
+		return nil
+	}
+	if len(bounds) != len(r.backoff) {
+		return ErrInvalidJitterBounds
+	}
+	for _, bound := range bounds {
+		if bound < 0 {
+			return ErrInvalidJitterBounds
+		}
+	}
+	r.jitterBounds = make([]time.Duration, len(bounds))
+	copy(r.jitterBounds, bounds)
+	return nil
 }
 
 // SetJitter sets the amount of jitter on each back-off to a factor between 0.0 and 1.0 (values outside this range
@@ -3,6 +3,14 @@ package retrier
 import (
 	"context"
 	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"math/rand"
+	"runtime/debug"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -103,6 +111,138 @@ func TestRetrierCtxError(t *testing.T) {
 	}
 }
 
+func TestRetrierCtxDeadlineDuringBackoff(t *testing.T) {
+	r := New(ConstantBackoff(5, 5*time.Millisecond), nil)
+
+	// the deadline always passes before the back-off ends, so no retry may ever start
+	for i := 0; i < 20; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+		attempts := 0
+		err := r.RunCtx(ctx, func(ctx context.Context) error {
+			attempts++
+			return errFoo
+		})
+		cancel()
+		if err != context.DeadlineExceeded || attempts != 1 {
+			t.Fatal("extra attempt after the deadline", err, attempts)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 12*time.Millisecond)
+	defer cancel()
+	attempts := 0
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		return errFoo
+	})
+	if err != context.DeadlineExceeded || attempts > 3 {
+		t.Error("extra attempt after the deadline", err, attempts)
+	}
+}
+
+func TestRetrierNoBackoffAfterFinalAttempt(t *testing.T) {
+	var backoffs []time.Duration
+	notify := func(err error, attempt int, backoff time.Duration) {
+		backoffs = append(backoffs, backoff)
+	}
+	r := New([]time.Duration{time.Millisecond, time.Hour}, WhitelistClassifier{errFoo}).WithNotify(notify)
+
+	// the hour-long back-off would come next, but the run ends with Fail first
+	start := time.Now()
+	attempts := 0
+	history, err := r.RunWithHistoryCtx(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if attempts < 2 {
+			return errFoo
+		}
+		return errBar
+	})
+	if err != errBar || len(history) != 2 {
+		t.Fatal(err, history)
+	}
+	if len(backoffs) != 1 || history[1].Backoff != 0 || time.Since(start) > time.Second {
+		t.Error("backed off before a terminal decision", backoffs, history[1].Backoff)
+	}
+
+	// likewise when the retries are exhausted
+	backoffs = nil
+	r = New([]time.Duration{time.Millisecond}, nil).WithMaxAttempts(2).WithNotify(notify)
+	r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if i != 2 || len(backoffs) != 1 {
+		t.Error("backed off after the final attempt", i, backoffs)
+	}
+}
+
+func TestRetrierCtxCause(t *testing.T) {
+	errShutdown := errors.New("shutting down")
+	r := New(ConstantBackoff(5, time.Hour), nil)
+
+	ctx, cancel := context.WithCancelCause(context.Background())
+	attempts := 0
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		cancel(errShutdown)
+		return errFoo
+	})
+	if err != errShutdown || attempts != 1 {
+		t.Error("cause not returned", err, attempts)
+	}
+
+	gate := &Gate{}
+	gate.Pause()
+	ctx, cancel = context.WithCancelCause(context.Background())
+	cancel(errShutdown)
+	if err := New(nil, nil).WithGate(gate).RunCtx(ctx, func(ctx context.Context) error { return nil }); err != errShutdown {
+		t.Error("cause not returned from a paused gate", err)
+	}
+
+	// without a cause, the context's error is returned as before
+	ctx, stop := context.WithCancel(context.Background())
+	stop()
+	err = r.RunCtx(ctx, func(ctx context.Context) error { return errFoo })
+	if err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestRetrierWithDeadlineReserve(t *testing.T) {
+	const reserve = 40 * time.Millisecond
+	r := New(ConstantBackoff(100, 10*time.Millisecond), nil).WithDeadlineReserve(reserve)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	deadline, _ := ctx.Deadline()
+	attempts := 0
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		attempts++
+		if workDeadline, _ := ctx.Deadline(); !workDeadline.Equal(deadline.Add(-reserve)) {
+			t.Error("work deadline not reserved", deadline.Sub(workDeadline))
+		}
+		return errFoo
+	})
+	if err != errFoo || attempts < 2 {
+		t.Error(err, attempts)
+	}
+	if remaining := time.Until(deadline); remaining < reserve {
+		t.Error("reserve not kept", remaining)
+	}
+
+	// work which waits for its context returns once the reserve is reached
+	ctx, cancel = context.WithTimeout(context.Background(), 60*time.Millisecond)
+	defer cancel()
+	deadline, _ = ctx.Deadline()
+	err = r.RunCtx(ctx, func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded || ctx.Err() != nil {
+		t.Error(err, ctx.Err())
+	}
+	if remaining := time.Until(deadline); remaining < reserve-10*time.Millisecond {
+		t.Error("reserve not kept", remaining)
+	}
+}
+
 func TestRetrierRunFnError(t *testing.T) {
 	ctx := context.Background()
 	r := New([]time.Duration{0, 10 * time.Millisecond}, nil)
@@ -153,6 +293,133 @@ func TestRetrierRunFnWithInfinite(t *testing.T) {
 	}
 }
 
+func TestRetrierInfiniteWithDefaultInterval(t *testing.T) {
+	r := New(nil, nil).WithInfiniteRetry()
+	if err := r.Run(genWork([]error{errFoo, errFoo})); err != nil {
+		t.Error(err)
+	}
+	if sleeps := r.DryRun(3); len(sleeps) != 3 || sleeps[0] != 0 || sleeps[2] != 0 {
+		t.Error("empty backoff did not busy-retry", sleeps)
+	}
+
+	var backoffs []time.Duration
+	r = New([]time.Duration{time.Millisecond}, nil).WithInfiniteRetry().WithDefaultInterval(5 * time.Millisecond).
+		WithNotify(func(err error, attempt int, backoff time.Duration) {
+			backoffs = append(backoffs, backoff)
+		})
+	if err := r.Run(genWork([]error{errFoo, errFoo, errFoo})); err != nil {
+		t.Error(err)
+	}
+	expected := []time.Duration{time.Millisecond, 5 * time.Millisecond, 5 * time.Millisecond}
+	if len(backoffs) != len(expected) {
+		t.Fatal("wrong number of backoffs", backoffs)
+	}
+	for i := range expected {
+		if backoffs[i] != expected[i] {
+			t.Error("default interval not used", backoffs)
+		}
+	}
+}
+
+// conditionClassifier retries nil until the condition is met
+type conditionClassifier func() bool
+
+func (c conditionClassifier) Classify(err error) Action {
+	if err != nil {
+		return Retry
+	}
+	if c() {
+		return Succeed
+	}
+	return Retry
+}
+
+func TestRetrierWithClassifyNil(t *testing.T) {
+	calls := 0
+	ready := conditionClassifier(func() bool { return calls >= 3 })
+	work := func() error {
+		calls++
+		return nil
+	}
+
+	r := New([]time.Duration{0, 0, 0, 0}, ready)
+	if err := r.Run(work); err != nil {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Error("classifier consulted on nil by default", calls)
+	}
+
+	calls = 0
+	r.WithClassifyNil(true)
+	if err := r.Run(work); err != nil {
+		t.Error(err)
+	}
+	if calls != 3 {
+		t.Error("did not retry until the condition flipped", calls)
+	}
+
+	calls = -10
+	if err := r.Run(work); err != nil {
+		t.Error(err)
+	}
+	if calls != -5 {
+		t.Error("did not stop when retries were exhausted", calls)
+	}
+
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("unbounded infinite retrier did not panic")
+			}
+		}()
+		_ = New(nil, ready).WithInfiniteRetry().WithClassifyNil(true).Run(work)
+	}()
+}
+
+func TestRetrierSubOperationDeadline(t *testing.T) {
+	subOperation := func(ctx context.Context) error {
+		ctx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer cancel()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	// the parent context has plenty of time left, so the sub-operation is retried
+	r := New([]time.Duration{time.Millisecond, time.Millisecond}, nil)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	calls := 0
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		calls++
+		return subOperation(ctx)
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if calls != 3 {
+		t.Error("sub-operation deadline not retried", calls)
+	}
+
+	// the parent context would be done before the back-off ends, so the retrier gives up straight away
+	r = New([]time.Duration{5 * time.Second}, nil)
+	calls = 0
+	st := time.Now()
+	err = r.RunCtx(ctx, func(ctx context.Context) error {
+		calls++
+		return subOperation(ctx)
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Error("retried without time left", calls)
+	}
+	if elapsed := time.Since(st); elapsed > 500*time.Millisecond {
+		t.Error("did not return fast", elapsed)
+	}
+}
+
 func TestRetrierWithDynamicBackoff(t *testing.T) {
 	r := New([]time.Duration{0, 10 * time.Millisecond}, nil)
 	st := time.Now()
@@ -171,6 +438,89 @@ func TestRetrierWithDynamicBackoff(t *testing.T) {
 
 }
 
+// retryInfoErr hints a back-off without using ErrWithBackoff
+type retryInfoErr struct {
+	backoff time.Duration
+	ok      bool
+}
+
+func (e retryInfoErr) Error() string                       { return "try again later" }
+func (e retryInfoErr) RetryBackoff() (time.Duration, bool) { return e.backoff, e.ok }
+
+func TestRetrierBackoffHinter(t *testing.T) {
+	var backoffs []time.Duration
+	r := New([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, nil).
+		WithNotify(func(err error, attempt int, backoff time.Duration) {
+			backoffs = append(backoffs, backoff)
+		})
+
+	err := r.Run(genWork([]error{
+		retryInfoErr{backoff: 20 * time.Millisecond, ok: true},
+		wrappedErr{error: retryInfoErr{backoff: 30 * time.Millisecond, ok: true}},
+		retryInfoErr{backoff: time.Hour, ok: false},
+	}))
+	if err != nil {
+		t.Error(err)
+	}
+
+	expected := []time.Duration{20 * time.Millisecond, 30 * time.Millisecond, time.Millisecond}
+	if len(backoffs) != len(expected) {
+		t.Fatal("wrong number of backoffs", backoffs)
+	}
+	for i := range expected {
+		if backoffs[i] != expected[i] {
+			t.Error("hinted backoff not honoured", backoffs)
+		}
+	}
+}
+
+type backoffClassifier map[error]time.Duration
+
+func (c backoffClassifier) Classify(err error) Action {
+	action, _, _ := c.ClassifyBackoff(err)
+	return action
+}
+
+func (c backoffClassifier) ClassifyBackoff(err error) (Action, time.Duration, bool) {
+	if err == nil {
+		return Succeed, 0, false
+	}
+	backoff, ok := c[err]
+	return Retry, backoff, ok
+}
+
+func TestRetrierWithBackoffClassifier(t *testing.T) {
+	var backoffs []time.Duration
+	r := New([]time.Duration{time.Millisecond, time.Millisecond, time.Millisecond}, backoffClassifier{
+		errFoo: 20 * time.Millisecond,
+		errBar: 0,
+	}).WithNotify(func(err error, attempt int, backoff time.Duration) {
+		backoffs = append(backoffs, backoff)
+	})
+
+	st := time.Now()
+	err := r.Run(genWork([]error{errFoo, errBar, errBaz}))
+	if err != nil {
+		t.Error(err)
+	}
+	if i != 4 {
+		t.Error("run wrong number of times")
+	}
+	if time.Since(st) < 20*time.Millisecond {
+		t.Error("did not wait for classifier backoff")
+	}
+
+	expected := []time.Duration{20 * time.Millisecond, 0, time.Millisecond}
+	if len(backoffs) != len(expected) {
+		t.Fatal("wrong number of backoffs", backoffs)
+	}
+	for i := range expected {
+		if backoffs[i] != expected[i] {
+			t.Error("incorrect backoff", backoffs[i])
+		}
+	}
+}
+
 func TestRetrierRunFnWithSurfaceWorkErrors(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -217,84 +567,1137 @@ func TestRetrierRunFnWithoutSurfaceWorkErrors(t *testing.T) {
 	}
 }
 
-func TestRetrierNone(t *testing.T) {
-	r := New(nil, nil)
+func TestRetrierWithExhaustionError(t *testing.T) {
+	r := New([]time.Duration{0, 0}, WhitelistClassifier{errFoo}).WithExhaustionError()
 
-	i = 0
-	err := r.Run(func() error {
-		i++
-		return errFoo
+	err := r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) {
+		t.Fatal(err)
+	}
+	if exhausted.Attempts != 3 {
+		t.Error("incorrect attempt count", exhausted.Attempts)
+	}
+	if exhausted.Last != errFoo || !errors.Is(err, errFoo) {
+		t.Error("exhaustion error does not wrap the last error")
+	}
+
+	// non-retriable errors are returned as-is
+	err = r.Run(genWork([]error{errFoo, errBar}))
+	if err != errBar {
+		t.Error(err)
+	}
+
+	// without the option the last error is returned directly
+	r = New([]time.Duration{0, 0}, WhitelistClassifier{errFoo})
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+}
+
+func TestRetrierWithOnSuccess(t *testing.T) {
+	calls := 0
+	var attempts int
+	var elapsed time.Duration
+	r := New([]time.Duration{5 * time.Millisecond, 5 * time.Millisecond}, nil).WithOnSuccess(func(a int, e time.Duration) {
+		calls++
+		attempts = a
+		elapsed = e
 	})
+
+	err := r.Run(genWork([]error{errFoo, errFoo}))
+	if err != nil {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Error("callback called wrong number of times", calls)
+	}
+	if attempts != 3 {
+		t.Error("incorrect attempt count", attempts)
+	}
+	if elapsed < 10*time.Millisecond {
+		t.Error("incorrect elapsed time", elapsed)
+	}
+
+	calls = 0
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo}))
 	if err != errFoo {
 		t.Error(err)
 	}
-	if i != 1 {
-		t.Error("run wrong number of times")
+	if calls != 0 {
+		t.Error("callback called on failure")
 	}
+}
 
-	i = 0
-	err = r.Run(func() error {
-		i++
-		return nil
+func TestRetrierWithOnClassifierFail(t *testing.T) {
+	calls := 0
+	var lastErr error
+	var attempt int
+	r := New([]time.Duration{0, 0}, WhitelistClassifier{errFoo}).WithOnClassifierFail(func(err error, a int) {
+		calls++
+		lastErr = err
+		attempt = a
 	})
+
+	err := r.Run(genWork([]error{errFoo, errBar}))
+	if err != errBar {
+		t.Error(err)
+	}
+	if calls != 1 || lastErr != errBar || attempt != 2 {
+		t.Error("callback called incorrectly", calls, lastErr, attempt)
+	}
+
+	calls = 0
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	if calls != 0 {
+		t.Error("callback called on exhaustion")
+	}
+
+	err = r.Run(genWork([]error{errFoo}))
 	if err != nil {
 		t.Error(err)
 	}
-	if i != 1 {
-		t.Error("run wrong number of times")
+	if calls != 0 {
+		t.Error("callback called on success")
 	}
 }
 
-func TestRetrierJitter(t *testing.T) {
-	r := New([]time.Duration{0, 10 * time.Millisecond, 4 * time.Hour}, nil)
+func TestRetrierWithClassifierObserver(t *testing.T) {
+	counts := map[Action]int{}
+	var errs []error
+	r := New([]time.Duration{0, 0, 0}, WhitelistClassifier{errFoo}).WithClassifierObserver(func(action Action, err error) {
+		counts[action]++
+		errs = append(errs, err)
+	})
 
-	if r.calcSleep(0) != 0 {
-		t.Error("Incorrect sleep calculated")
+	err := r.Run(genWork([]error{errFoo, errFoo}))
+	if err != nil {
+		t.Error(err)
 	}
-	if r.calcSleep(1) != 10*time.Millisecond {
-		t.Error("Incorrect sleep calculated")
+	err = r.Run(genWork([]error{errFoo, errBar}))
+	if err != errBar {
+		t.Error(err)
 	}
-	if r.calcSleep(2) != 4*time.Hour {
-		t.Error("Incorrect sleep calculated")
+
+	if counts[Retry] != 3 || counts[Fail] != 1 || counts[Succeed] != 1 {
+		t.Error("observer saw wrong action counts", counts)
+	}
+	if len(errs) != 5 || errs[2] != nil || errs[4] != errBar {
+		t.Error("observer saw wrong errors", errs)
 	}
+}
 
-	r.SetJitter(0.25)
-	for i := 0; i < 20; i++ {
-		if r.calcSleep(0) != 0 {
-			t.Error("Incorrect sleep calculated")
-		}
+var errTransientPanic = errors.New("transient panic")
 
-		slp := r.calcSleep(1)
-		if slp < 7500*time.Microsecond || slp > 12500*time.Microsecond {
-			t.Error("Incorrect sleep calculated")
+func panicsUnrecoverably() error {
+	panic("unrecoverable")
+}
+
+func TestRetrierWithRecoverIf(t *testing.T) {
+	r := New(ConstantBackoff(2, 0), WhitelistClassifier{errFoo}).WithRecoverIf(func(recovered any) bool {
+		return recovered == errTransientPanic
+	})
+
+	attempts := 0
+	err := r.Run(func() error {
+		attempts++
+		if attempts < 3 {
+			panic(errTransientPanic)
 		}
+		return nil
+	})
+	if err != nil || attempts != 3 {
+		t.Error("matching panic not retried", err, attempts)
+	}
 
-		slp = r.calcSleep(2)
-		if slp < 3*time.Hour || slp > 5*time.Hour {
-			t.Error("Incorrect sleep calculated")
+	err = r.Run(func() error { panic(errTransientPanic) })
+	var panicErr *PanicError
+	if !errors.As(err, &panicErr) || panicErr.Value != errTransientPanic || len(panicErr.Stack) == 0 {
+		t.Error(err)
+	}
+
+	func() {
+		defer func() {
+			if recovered := recover(); recovered != "unrecoverable" {
+				t.Error("wrong panic", recovered)
+			}
+			if !strings.Contains(string(debug.Stack()), "panicsUnrecoverably") {
+				t.Error("original stack not preserved")
+			}
+		}()
+		r.Run(panicsUnrecoverably)
+		t.Error("non-matching panic not re-raised")
+	}()
+}
+
+func TestRetrierWithResultValidator(t *testing.T) {
+	errNotReady := errors.New("not ready")
+	validations := 0
+	r := New([]time.Duration{0, 0, 0}, WhitelistClassifier{errNotReady}).WithResultValidator(func() error {
+		validations++
+		if validations < 3 {
+			return errNotReady
 		}
+		return nil
+	})
+
+	calls := 0
+	err := r.Run(func() error {
+		calls++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if calls != 3 || validations != 3 {
+		t.Error("wrong number of attempts", calls, validations)
 	}
 
-	r.SetJitter(-1)
-	if r.jitter != 0.25 {
-		t.Error("Invalid jitter value accepted")
+	// the validator is not consulted when the work itself fails
+	validations = 0
+	err = r.Run(genWork([]error{errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	if validations != 0 {
+		t.Error("validator called after a failed attempt")
 	}
 
-	r.SetJitter(2)
-	if r.jitter != 0.25 {
-		t.Error("Invalid jitter value accepted")
+	// validation errors are subject to the classifier like any other
+	r.WithResultValidator(func() error { return errBar })
+	if err := r.Run(genWork(nil)); err != errBar {
+		t.Error(err)
 	}
 }
 
-func TestRetrierThreadSafety(t *testing.T) {
-	r := New([]time.Duration{0}, nil)
-	for i := 0; i < 2; i++ {
-		go func() {
-			r.Run(func() error {
-				return errors.New("error")
-			})
+func TestRetrierWithCleanup(t *testing.T) {
+	var attempts []int
+	r := New([]time.Duration{0, 0}, nil).WithCleanup(func(ctx context.Context, attempt int, err error) error {
+		if err != errFoo {
+			t.Error("cleanup passed wrong error", err)
+		}
+		attempts = append(attempts, attempt)
+		return nil
+	})
+
+	// cleanup succeeds, so retries proceed
+	if err := r.Run(genWork([]error{errFoo, errFoo})); err != nil {
+		t.Error(err)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Error("cleanup called incorrectly", attempts)
+	}
+
+	// not called after the final attempt
+	attempts = nil
+	if err := r.Run(genWork([]error{errFoo, errFoo, errFoo})); err != errFoo {
+		t.Error(err)
+	}
+	if len(attempts) != 2 {
+		t.Error("cleanup called incorrectly", attempts)
+	}
+
+	// cleanup fails, so the run is aborted
+	errRollback := errors.New("rollback failed")
+	calls := 0
+	r.WithCleanup(func(ctx context.Context, attempt int, err error) error {
+		return errRollback
+	})
+	err := r.Run(func() error {
+		calls++
+		return errFoo
+	})
+	if err != errRollback {
+		t.Error(err)
+	}
+	if calls != 1 {
+		t.Error("retried after cleanup failed", calls)
+	}
+}
+
+func TestRetrierWithFinalAttempt(t *testing.T) {
+	var lastErr error
+	var succeeded []int
+	r := New([]time.Duration{0, 0}, WhitelistClassifier{errFoo}).WithExhaustionError().
+		WithFinalAttempt(func(ctx context.Context, err error) error {
+			lastErr = err
+			return nil
+		}).
+		WithOnSuccess(func(attempts int, totalElapsed time.Duration) {
+			succeeded = append(succeeded, attempts)
+		})
+
+	// the fallback turns an exhausted failure into a success
+	if err := r.Run(genWork([]error{errFoo, errFoo, errFoo})); err != nil {
+		t.Error(err)
+	}
+	if lastErr != errFoo {
+		t.Error("final attempt passed wrong error", lastErr)
+	}
+	if len(succeeded) != 1 || succeeded[0] != 3 {
+		t.Error("recovered run not reported as a success", succeeded)
+	}
+	succeeded = nil
+
+	// but is not used for other failures
+	lastErr = nil
+	if err := r.Run(genWork([]error{errFoo, errBar})); err != errBar {
+		t.Error(err)
+	}
+	if err := r.Run(genWork(nil)); err != nil {
+		t.Error(err)
+	}
+	if lastErr != nil {
+		t.Error("final attempt called when not exhausted")
+	}
+
+	r.WithFinalAttempt(func(ctx context.Context, err error) error {
+		return errBaz
+	})
+	if err := r.Run(genWork([]error{errFoo, errFoo, errFoo})); err != errBaz {
+		t.Error(err)
+	}
+	if len(succeeded) != 1 {
+		t.Error("wrong successes reported", succeeded)
+	}
+}
+
+func TestRetrierWithMaxConcurrent(t *testing.T) {
+	r := New([]time.Duration{time.Millisecond, time.Millisecond}, nil).WithMaxConcurrent(3)
+
+	var inFlight, maxInFlight int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = r.RunFn(context.Background(), func(ctx context.Context, retries int) error {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					max := atomic.LoadInt32(&maxInFlight)
+					if n <= max || atomic.CompareAndSwapInt32(&maxInFlight, max, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				if retries < 2 {
+					return errFoo
+				}
+				return nil
+			})
 		}()
 	}
+	wg.Wait()
+
+	if max := atomic.LoadInt32(&maxInFlight); max > 3 || max < 1 {
+		t.Error("incorrect number of concurrent executions:", max)
+	}
+}
+
+func TestRetrierWithMaxConcurrentCtx(t *testing.T) {
+	r := New(nil, nil).WithMaxConcurrent(1)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	go func() {
+		_ = r.Run(func() error {
+			close(started)
+			<-release
+			return nil
+		})
+	}()
+	<-started
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	i = 0
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		i++
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	if i != 0 {
+		t.Error("run wrong number of times")
+	}
+
+	close(release)
+}
+
+func TestRetrierWithFirstAttemptDelay(t *testing.T) {
+	backoff := []time.Duration{20 * time.Millisecond, 10 * time.Millisecond}
+
+	for _, delay := range []bool{false, true} {
+		r := New(backoff, nil).WithFirstAttemptDelay(delay)
+
+		var offsets []time.Duration
+		st := time.Now()
+		err := r.Run(func() error {
+			offsets = append(offsets, time.Since(st))
+			return errFoo
+		})
+		if err != errFoo {
+			t.Error(err)
+		}
+		if len(offsets) != 3 {
+			t.Fatal("run wrong number of times", len(offsets))
+		}
+
+		first := time.Duration(0)
+		if delay {
+			first = 20 * time.Millisecond
+		}
+		if offsets[0] < first || offsets[0] > first+15*time.Millisecond {
+			t.Error("incorrect delay before first attempt", delay, offsets[0])
+		}
+		if offsets[1]-offsets[0] < 20*time.Millisecond {
+			t.Error("incorrect delay before second attempt", delay, offsets[1]-offsets[0])
+		}
+		if offsets[2]-offsets[1] < 10*time.Millisecond {
+			t.Error("incorrect delay before third attempt", delay, offsets[2]-offsets[1])
+		}
+	}
+
+	// the initial delay respects the context
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	r := New(backoff, nil).WithFirstAttemptDelay(true)
+	err := r.RunCtx(ctx, func(ctx context.Context) error {
+		t.Error("work ran after context was cancelled")
+		return nil
+	})
+	if err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+type contextKey string
+
+func TestRetrierWithContextValues(t *testing.T) {
+	values := map[any]any{
+		contextKey("operation"): "lookup",
+		contextKey("tenant"):    42,
+	}
+	r := New(ConstantBackoff(2, 0), nil).WithContextValues(values)
+	values[contextKey("operation")] = "mutated"
+
+	attempts := 0
+	err := r.RunCtx(context.Background(), func(ctx context.Context) error {
+		attempts++
+		if ctx.Value(contextKey("operation")) != "lookup" || ctx.Value(contextKey("tenant")) != 42 {
+			t.Error("context values missing on attempt", attempts)
+		}
+		return errFoo
+	})
+	if err != errFoo {
+		t.Error(err)
+	}
+	if attempts != 3 {
+		t.Error("run wrong number of times")
+	}
+}
+
+func TestRetrierWithIdempotencyKey(t *testing.T) {
+	generated := 0
+	r := New(ConstantBackoff(2, 0), nil).WithIdempotencyKey(func() string {
+		generated++
+		return fmt.Sprintf("key-%d", generated)
+	})
+
+	var keys []string
+	work := func(ctx context.Context) error {
+		key, ok := IdempotencyKeyFromContext(ctx)
+		if !ok {
+			t.Error("idempotency key missing")
+		}
+		keys = append(keys, key)
+		return errFoo
+	}
+	for i := 0; i < 2; i++ {
+		if err := r.RunCtx(context.Background(), work); err != errFoo {
+			t.Error(err)
+		}
+	}
+
+	if generated != 2 || len(keys) != 6 {
+		t.Fatal("wrong number of keys", generated, keys)
+	}
+	for i, key := range keys {
+		expected := "key-1"
+		if i >= 3 {
+			expected = "key-2"
+		}
+		if key != expected {
+			t.Error("wrong key on attempt", i, key)
+		}
+	}
+
+	if _, ok := IdempotencyKeyFromContext(context.Background()); ok {
+		t.Error("idempotency key found without a retrier")
+	}
+}
+
+type recordingHandler struct {
+	records []slog.Record
+}
+
+func (h *recordingHandler) Enabled(context.Context, slog.Level) bool { return true }
+func (h *recordingHandler) WithAttrs([]slog.Attr) slog.Handler       { return h }
+func (h *recordingHandler) WithGroup(string) slog.Handler            { return h }
+
+func (h *recordingHandler) Handle(_ context.Context, record slog.Record) error {
+	h.records = append(h.records, record)
+	return nil
+}
+
+func recordAttrs(record slog.Record) map[string]slog.Value {
+	attrs := make(map[string]slog.Value)
+	record.Attrs(func(attr slog.Attr) bool {
+		attrs[attr.Key] = attr.Value
+		return true
+	})
+	return attrs
+}
+
+func TestRetrierWithLogger(t *testing.T) {
+	h := &recordingHandler{}
+	r := New([]time.Duration{0, 10 * time.Millisecond}, nil).WithLogger(slog.New(h))
+
+	err := r.Run(genWork([]error{errFoo, errBar, errBaz}))
+	if err != errBaz {
+		t.Error(err)
+	}
+	if len(h.records) != 3 {
+		t.Fatal("wrong number of log records:", len(h.records))
+	}
+
+	for i, record := range h.records[:2] {
+		if record.Level != slog.LevelDebug {
+			t.Error("incorrect level", record.Level)
+		}
+		attrs := recordAttrs(record)
+		if attrs["attempt"].Int64() != int64(i+1) {
+			t.Error("incorrect attempt", attrs["attempt"])
+		}
+		if attrs["backoff"].Duration() != r.backoff[i] {
+			t.Error("incorrect backoff", attrs["backoff"])
+		}
+		if attrs["error"].Any() != []error{errFoo, errBar}[i] {
+			t.Error("incorrect error", attrs["error"])
+		}
+	}
+
+	record := h.records[2]
+	if record.Level != slog.LevelWarn {
+		t.Error("incorrect level", record.Level)
+	}
+	attrs := recordAttrs(record)
+	if attrs["attempts"].Int64() != 3 {
+		t.Error("incorrect attempts", attrs["attempts"])
+	}
+	if attrs["error"].Any() != errBaz {
+		t.Error("incorrect error", attrs["error"])
+	}
+
+	// successful runs log nothing
+	h.records = nil
+	if err := r.Run(genWork(nil)); err != nil {
+		t.Error(err)
+	}
+	if len(h.records) != 0 {
+		t.Error("wrong number of log records:", len(h.records))
+	}
+}
+
+func TestRetrierWithMaxAttempts(t *testing.T) {
+	r := New(ConstantBackoff(5, 0), nil).WithMaxAttempts(2)
+
+	err := r.Run(genWork([]error{errFoo, errBar, errBaz}))
+	if err != errBar {
+		t.Error(err)
+	}
+	if i != 2 {
+		t.Error("run wrong number of times")
+	}
+
+	r = New([]time.Duration{0}, nil).WithInfiniteRetry().WithMaxAttempts(4)
+	err = r.Run(genWork([]error{errFoo, errFoo, errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	if i != 4 {
+		t.Error("run wrong number of times")
+	}
+}
+
+func TestRetrierWithMaxElapsedTime(t *testing.T) {
+	r := New(ConstantBackoff(100, 5*time.Millisecond), nil).WithMaxElapsedTime(22 * time.Millisecond)
+
+	st := time.Now()
+	i = 0
+	err := r.Run(func() error {
+		i++
+		return errFoo
+	})
+	if err != errFoo {
+		t.Error(err)
+	}
+	if elapsed := time.Since(st); elapsed > 22*time.Millisecond {
+		t.Error("ran for too long", elapsed)
+	}
+	if i < 2 || i > 5 {
+		t.Error("run wrong number of times", i)
+	}
+
+	// a back-off which would overrun the budget is never waited for
+	r = New([]time.Duration{time.Second}, nil).WithMaxElapsedTime(100 * time.Millisecond).WithExhaustionError()
+	st = time.Now()
+	err = r.Run(genWork([]error{errFoo}))
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || exhausted.Attempts != 1 {
+		t.Error(err)
+	}
+	if time.Since(st) > 100*time.Millisecond {
+		t.Error("waited for back-off")
+	}
+}
+
+func TestRetrierWithNotify(t *testing.T) {
+	var errs []error
+	var attempts []int
+	r := New([]time.Duration{0, 10 * time.Millisecond}, nil).WithNotify(func(err error, attempt int, backoff time.Duration) {
+		errs = append(errs, err)
+		attempts = append(attempts, attempt)
+		if backoff != []time.Duration{0, 10 * time.Millisecond}[attempt-1] {
+			t.Error("incorrect backoff", backoff)
+		}
+	})
+
+	err := r.Run(genWork([]error{errFoo, errBar, errBaz}))
+	if err != errBaz {
+		t.Error(err)
+	}
+	if len(errs) != 2 || errs[0] != errFoo || errs[1] != errBar {
+		t.Error("incorrect errors notified", errs)
+	}
+	if len(attempts) != 2 || attempts[0] != 1 || attempts[1] != 2 {
+		t.Error("incorrect attempts notified", attempts)
+	}
+}
+
+func TestRetrierWithAttemptTimeouts(t *testing.T) {
+	r := New(ConstantBackoff(3, 0), nil).WithAttemptTimeouts([]time.Duration{5 * time.Millisecond, 10 * time.Millisecond, 1 * time.Second})
+
+	var budgets []time.Duration
+	err := r.RunFn(context.Background(), func(ctx context.Context, retries int) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("attempt has no deadline")
+		}
+		budgets = append(budgets, time.Until(deadline))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(20 * time.Millisecond):
+			return nil
+		}
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if len(budgets) != 3 {
+		t.Fatal("run wrong number of times", len(budgets))
+	}
+	if budgets[0] > 5*time.Millisecond || budgets[1] > 10*time.Millisecond || budgets[2] < 500*time.Millisecond {
+		t.Error("incorrect attempt budgets", budgets)
+	}
+
+	// the last timeout repeats
+	budgets = nil
+	r = New(ConstantBackoff(3, 0), nil).WithAttemptTimeouts([]time.Duration{5 * time.Millisecond})
+	err = r.RunCtx(context.Background(), func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		budgets = append(budgets, time.Until(deadline))
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+	for _, budget := range budgets {
+		if budget > 5*time.Millisecond {
+			t.Error("incorrect attempt budget", budget)
+		}
+	}
+	if len(budgets) != 4 {
+		t.Error("run wrong number of times", len(budgets))
+	}
+}
+
+func TestRetrierWithSmartErrorAggregation(t *testing.T) {
+	r := New(ConstantBackoff(2, 0), BlacklistClassifier{errBaz}).WithSmartErrorAggregation()
+
+	// all the same
+	err := r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+	wrapped := wrappedErr{error: errFoo}
+	err = r.Run(genWork([]error{errFoo, wrapped, wrapped}))
+	if err != wrapped {
+		t.Error(err)
+	}
+
+	// all different
+	err = r.Run(genWork([]error{errFoo, errBar, errFoo}))
+	if !errors.Is(err, errFoo) || !errors.Is(err, errBar) {
+		t.Error(err)
+	}
+	if err.Error() != "FOO\nBAR" {
+		t.Error("incorrect joined error:", err)
+	}
+
+	// classifier failures are aggregated too
+	err = r.Run(genWork([]error{errFoo, errBaz}))
+	if !errors.Is(err, errFoo) || !errors.Is(err, errBaz) || err.Error() != "FOO\nBAZ" {
+		t.Error(err)
+	}
+
+	// success is unaffected
+	err = r.Run(genWork([]error{errFoo, errBar}))
+	if err != nil {
+		t.Error(err)
+	}
+
+	r.WithExhaustionError()
+	err = r.Run(genWork([]error{errFoo, errBar, errFoo}))
+	var exhausted *ExhaustedError
+	if !errors.As(err, &exhausted) || !errors.Is(exhausted.Last, errBar) {
+		t.Error(err)
+	}
+}
+
+func TestIsExhausted(t *testing.T) {
+	r := New([]time.Duration{0, 0}, WhitelistClassifier{errFoo}).WithExhaustionError()
+
+	err := r.Run(genWork([]error{errFoo, errFoo, errFoo}))
+	if !IsExhausted(err) {
+		t.Error("exhaustion not detected", err)
+	}
+	if !IsExhausted(wrappedErr{error: err}) {
+		t.Error("wrapped exhaustion not detected", err)
+	}
+
+	err = r.Run(genWork([]error{errBar}))
+	if IsExhausted(err) {
+		t.Error("classifier failure detected as exhaustion", err)
+	}
+
+	r = New([]time.Duration{time.Second, time.Second}, WhitelistClassifier{errFoo}).WithExhaustionError()
+	ctx, cancel := context.WithCancel(context.Background())
+	err = r.RunCtx(ctx, func(ctx context.Context) error {
+		cancel()
+		return errFoo
+	})
+	if err != context.Canceled || IsExhausted(err) {
+		t.Error("cancellation detected as exhaustion", err)
+	}
+
+	if IsExhausted(nil) {
+		t.Error("success detected as exhaustion")
+	}
+}
+
+func TestRetrierNone(t *testing.T) {
+	r := New(nil, nil)
+
+	i = 0
+	err := r.Run(func() error {
+		i++
+		return errFoo
+	})
+	if err != errFoo {
+		t.Error(err)
+	}
+	if i != 1 {
+		t.Error("run wrong number of times")
+	}
+
+	i = 0
+	err = r.Run(func() error {
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Error(err)
+	}
+	if i != 1 {
+		t.Error("run wrong number of times")
+	}
+}
+
+func TestRetrierJitter(t *testing.T) {
+	r := New([]time.Duration{0, 10 * time.Millisecond, 4 * time.Hour}, nil)
+
+	if r.calcSleep(0) != 0 {
+		t.Error("Incorrect sleep calculated")
+	}
+	if r.calcSleep(1) != 10*time.Millisecond {
+		t.Error("Incorrect sleep calculated")
+	}
+	if r.calcSleep(2) != 4*time.Hour {
+		t.Error("Incorrect sleep calculated")
+	}
+
+	r.SetJitter(0.25)
+	for i := 0; i < 20; i++ {
+		if r.calcSleep(0) != 0 {
+			t.Error("Incorrect sleep calculated")
+		}
+
+		slp := r.calcSleep(1)
+		if slp < 7500*time.Microsecond || slp > 12500*time.Microsecond {
+			t.Error("Incorrect sleep calculated")
+		}
+
+		slp = r.calcSleep(2)
+		if slp < 3*time.Hour || slp > 5*time.Hour {
+			t.Error("Incorrect sleep calculated")
+		}
+	}
+
+	r.SetJitter(-1)
+	if r.jitter != 0.25 {
+		t.Error("Invalid jitter value accepted")
+	}
+
+	r.SetJitter(2)
+	if r.jitter != 0.25 {
+		t.Error("Invalid jitter value accepted")
+	}
+}
+
+func TestRetrierAbsoluteJitter(t *testing.T) {
+	r := New([]time.Duration{0, 10 * time.Millisecond}, nil).WithAbsoluteJitter(time.Millisecond)
+
+	seen := make(map[time.Duration]bool)
+	for i := 0; i < 20; i++ {
+		slp := r.calcSleep(0)
+		if slp < 0 || slp > time.Millisecond {
+			t.Error("Incorrect sleep calculated")
+		}
+		seen[slp] = true
+
+		slp = r.calcSleep(1)
+		if slp < 9*time.Millisecond || slp > 11*time.Millisecond {
+			t.Error("Incorrect sleep calculated")
+		}
+	}
+	if len(seen) < 2 {
+		t.Error("zero back-off was not spread out")
+	}
+
+	r.WithAbsoluteJitter(-1)
+	if r.absoluteJitter != time.Millisecond {
+		t.Error("Invalid absolute jitter value accepted")
+	}
+}
+
+func TestRetrierJitterBounds(t *testing.T) {
+	backoff := []time.Duration{10 * time.Millisecond, time.Second, 10 * time.Second}
+	bounds := []time.Duration{time.Millisecond, 100 * time.Millisecond, 0}
+	r := New(backoff, nil)
+
+	if err := r.SetJitterBounds(bounds[:2]); err != ErrInvalidJitterBounds {
+		t.Error(err)
+	}
+	if err := r.SetJitterBounds([]time.Duration{0, -time.Second, 0}); err != ErrInvalidJitterBounds {
+		t.Error(err)
+	}
+	if err := r.SetJitterBounds(bounds); err != nil {
+		t.Error(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		for k := range backoff {
+			sleep := r.calcSleep(k)
+			if sleep < backoff[k]-bounds[k] || sleep > backoff[k]+bounds[k] {
+				t.Error("sleep", k, "outside its bounds:", sleep)
+			}
+		}
+	}
+	if r.calcSleep(2) != 10*time.Second {
+		t.Error("zero bound applied jitter")
+	}
+
+	if err := r.SetJitterBounds(nil); err != nil {
+		t.Error(err)
+	}
+	if r.calcSleep(0) != 10*time.Millisecond {
+		t.Error("bounds not removed")
+	}
+}
+
+func TestRetrierWithBucketAlignment(t *testing.T) {
+	const bucket = 5 * time.Second
+	r := New(ConstantBackoff(3, time.Second), nil).WithBucketAlignment(bucket)
+
+	now := time.Unix(1000, int64(1500*time.Millisecond))
+	if sleep := r.align(now, time.Second, nil); sleep != 3500*time.Millisecond {
+		t.Error("sleep not rounded up to the bucket boundary", sleep)
+	}
+	if sleep := r.align(time.Unix(1004, 0), time.Second, nil); sleep != time.Second {
+		t.Error("sleep already on a boundary was changed", sleep)
+	}
+
+	sleeps := r.DryRun(3)
+	if len(sleeps) != 3 || sleeps[0] < time.Second || sleeps[0] > time.Second+bucket {
+		t.Fatal("wrong first sleep", sleeps)
+	}
+	if sleeps[1] != bucket || sleeps[2] != bucket {
+		t.Error("later sleeps not aligned to buckets", sleeps)
+	}
+
+	// absolute jitter lands after the boundary
+	r.WithAbsoluteJitter(100 * time.Millisecond)
+	for i := 0; i < 20; i++ {
+		sleep := r.align(now, time.Second, nil)
+		if sleep < 3500*time.Millisecond || sleep >= 3600*time.Millisecond {
+			t.Error("jitter outside the bucket", sleep)
+		}
+	}
+}
+
+func TestRetrierJitterDistribution(t *testing.T) {
+	// the minimum of two uniform samples, weighted towards shorter back-offs
+	var bases []time.Duration
+	weighted := func(base time.Duration, r *rand.Rand) time.Duration {
+		bases = append(bases, base)
+		return time.Duration(math.Min(r.Float64(), r.Float64()) * float64(base))
+	}
+
+	backoff := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond}
+	r := New(backoff, nil).WithRand(rand.New(rand.NewSource(42))).WithJitterDistribution(weighted)
+	r.SetJitter(0.5) // replaced by the distribution
+	first := r.DryRun(2)
+	if len(bases) != 2 || bases[0] != backoff[0] || bases[1] != backoff[1] {
+		t.Error("distribution not called with the base back-offs", bases)
+	}
+	for i, sleep := range first {
+		if sleep < 0 || sleep > backoff[i] {
+			t.Error("sleep not from the distribution", sleep)
+		}
+	}
+
+	// the same seed gives the same sleeps
+	r.WithRand(rand.New(rand.NewSource(42)))
+	second := r.DryRun(2)
+	if len(second) != 2 || first[0] != second[0] || first[1] != second[1] {
+		t.Error("distribution not deterministic", first, second)
+	}
+
+	r.WithJitterDistribution(func(base time.Duration, r *rand.Rand) time.Duration { return -base })
+	if sleep := r.calcSleep(0); sleep != 0 {
+		t.Error("negative sleep not clamped", sleep)
+	}
+}
+
+func TestRetrierWithPerCallRand(t *testing.T) {
+	const base = time.Millisecond
+	r := New(ConstantBackoff(3, base), nil).WithPerCallRand()
+	r.SetJitter(0.5)
+
+	var wg sync.WaitGroup
+	histories := make([]History, 10)
+	for i := range histories {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			histories[i], _ = r.RunWithHistoryCtx(context.Background(), func(ctx context.Context) error {
+				return errFoo
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	seen := map[time.Duration]bool{}
+	for _, history := range histories {
+		for _, record := range history[:len(history)-1] {
+			if record.Backoff < base/2 || record.Backoff > base*3/2 {
+				t.Error("backoff outside jitter bounds", record.Backoff)
+			}
+			seen[record.Backoff] = true
+		}
+	}
+	if len(seen) < 2 {
+		t.Error("per-call jitter was not randomized", seen)
+	}
+}
+
+func TestRetrierWithEvenlyPacedAttempts(t *testing.T) {
+	r := New(ConstantBackoff(3, 10*time.Millisecond), nil).
+		WithMaxElapsedTime(200 * time.Millisecond).WithEvenlyPacedAttempts()
+
+	var timeouts []time.Duration
+	err := r.RunCtx(context.Background(), func(ctx context.Context) error {
+		deadline, ok := ctx.Deadline()
+		if !ok {
+			t.Fatal("attempt has no deadline")
+		}
+		timeouts = append(timeouts, time.Until(deadline))
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	if err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	// 200ms over 4 attempts, then whatever is left (less the back-offs) over the remaining attempts
+	if len(timeouts) < 2 {
+		t.Fatal("wrong number of attempts", timeouts)
+	}
+	if timeouts[0] > 50*time.Millisecond || timeouts[0] < 40*time.Millisecond {
+		t.Error("wrong first timeout", timeouts[0])
+	}
+	for i := 1; i < len(timeouts); i++ {
+		if timeouts[i] >= timeouts[i-1] {
+			t.Error("timeouts did not shrink", timeouts)
+		}
+	}
+
+	// a fast attempt leaves its time for the others
+	r = New([]time.Duration{0}, nil).WithMaxElapsedTime(100 * time.Millisecond).WithEvenlyPacedAttempts()
+	timeouts = nil
+	_ = r.RunCtx(context.Background(), func(ctx context.Context) error {
+		deadline, _ := ctx.Deadline()
+		timeouts = append(timeouts, time.Until(deadline))
+		return errFoo
+	})
+	if len(timeouts) != 2 || timeouts[1] < 80*time.Millisecond {
+		t.Error("wrong timeouts", timeouts)
+	}
+}
+
+func TestRetrierDryRun(t *testing.T) {
+	backoff := []time.Duration{time.Millisecond, 2 * time.Millisecond, 3 * time.Millisecond}
+	r := New(backoff, nil).WithRand(rand.New(rand.NewSource(42))).WithAbsoluteJitter(time.Millisecond)
+	r.SetJitter(0.5)
+
+	sleeps := r.DryRun(5)
+	if len(sleeps) != 3 {
+		t.Fatal("wrong number of sleeps", sleeps)
+	}
+
+	var actual []time.Duration
+	r = New(backoff, nil).WithRand(rand.New(rand.NewSource(42))).WithAbsoluteJitter(time.Millisecond).
+		WithNotify(func(err error, attempt int, backoff time.Duration) {
+			actual = append(actual, backoff)
+		})
+	r.SetJitter(0.5)
+	err := r.Run(genWork([]error{errFoo, errFoo, errFoo, errFoo}))
+	if err != errFoo {
+		t.Error(err)
+	}
+
+	if len(actual) != len(sleeps) {
+		t.Fatal("wrong number of sleeps", actual)
+	}
+	for i := range sleeps {
+		if sleeps[i] != actual[i] {
+			t.Error("dry run does not match real run", sleeps, actual)
+		}
+	}
+
+	r = New(ConstantBackoff(5, 10*time.Millisecond), nil)
+	if sleeps := r.DryRun(2); len(sleeps) != 2 {
+		t.Error("wrong number of sleeps", sleeps)
+	}
+	r.WithMaxElapsedTime(35 * time.Millisecond)
+	if sleeps := r.DryRun(5); len(sleeps) != 3 {
+		t.Error("wrong number of sleeps", sleeps)
+	}
+}
+
+func TestRetrierThreadSafety(t *testing.T) {
+	r := New([]time.Duration{0}, nil)
+	for i := 0; i < 2; i++ {
+		go func() {
+			r.Run(func() error {
+				return errors.New("error")
+			})
+		}()
+	}
+}
+
+func TestRetrierClone(t *testing.T) {
+	r := New([]time.Duration{0, 10 * time.Millisecond}, WhitelistClassifier{errFoo}).WithExhaustionError()
+	r.SetJitter(0.25)
+
+	clone := r.Clone()
+	if len(clone.backoff) != 2 || clone.backoff[1] != 10*time.Millisecond {
+		t.Error("backoff not copied")
+	}
+	if clone.jitter != 0.25 || !clone.exhaustionError {
+		t.Error("options not copied")
+	}
+
+	clone.SetJitter(0.5)
+	clone.backoff[1] = time.Second
+	clone.WithInfiniteRetry()
+	if r.jitter != 0.25 || r.backoff[1] != 10*time.Millisecond || r.infiniteRetry {
+		t.Error("original mutated through the clone")
+	}
+
+	err := clone.Run(genWork([]error{errFoo, errBar}))
+	if err != errBar {
+		t.Error(err)
+	}
+}
+
+func TestRetrierCloneThreadSafety(t *testing.T) {
+	r := New([]time.Duration{0, time.Millisecond}, nil)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clone := r.Clone()
+			clone.SetJitter(float64(i) / 10)
+			_ = clone.Run(func() error {
+				return errFoo
+			})
+		}(i)
+	}
+	wg.Wait()
+
+	if r.jitter != 0 {
+		t.Error("original mutated through a clone")
+	}
+}
+
+func benchmarkRetrierJitter(b *testing.B, r *Retrier) {
+	r.SetJitter(0.25)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			r.Run(func() error { return errFoo })
+		}
+	})
+}
+
+func BenchmarkRetrierSharedRand(b *testing.B) {
+	benchmarkRetrierJitter(b, New(ConstantBackoff(2, 0), nil))
+}
+
+func BenchmarkRetrierPerCallRand(b *testing.B) {
+	benchmarkRetrierJitter(b, New(ConstantBackoff(2, 0), nil).WithPerCallRand())
 }
 
 func ExampleRetrier() {
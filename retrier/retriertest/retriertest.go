@@ -0,0 +1,144 @@
+// Package retriertest provides utilities for testing code which uses a retrier.Retrier, so that the sleeps and
+// jitter of its retries are deterministic and do not take any real time.
+package retriertest
+
+import (
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+// Epoch is the time at which the clocks of the retriers returned by New start.
+var Epoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// New constructs a retrier (as with retrier.New) whose every wait is instant and whose jitter comes from a
+// rand.Rand with the given seed, so that runs are fast and repeatable. The returned FakeClock (which advances
+// automatically, starting at Epoch) can be used to see how long the retrier would have waited in total.
+func New(backoff []time.Duration, class retrier.Classifier, seed int64) (*retrier.Retrier, *FakeClock) {
+	clock := NewFakeClock(Epoch).WithAutoAdvance()
+	r := retrier.New(backoff, class).WithClock(clock).WithRand(rand.New(rand.NewSource(seed)))
+	return r, clock
+}
+
+// FakeClock is a retrier.Clock whose time only moves when told to, for use with retrier.Retrier.WithClock.
+// By default its timers only fire when Advance moves the time past them; with WithAutoAdvance, every timer
+// instead moves the time forward and fires as soon as it is created. It is safe to use concurrently.
+type FakeClock struct {
+	lock        sync.Mutex
+	now         time.Time
+	autoAdvance bool
+	timers      []*fakeTimer
+	changed     chan struct{} // closed (and replaced) whenever a timer is created
+}
+
+// NewFakeClock constructs a FakeClock whose time starts at the given time.
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{
+		now:     start,
+		changed: make(chan struct{}),
+	}
+}
+
+// WithAutoAdvance configures the clock to advance by the duration of each new timer, and fire it, immediately.
+func (c *FakeClock) WithAutoAdvance() *FakeClock {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.autoAdvance = true
+	return c
+}
+
+// Now implements retrier.Clock.
+func (c *FakeClock) Now() time.Time {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.now
+}
+
+// NewTimer implements retrier.Clock.
+func (c *FakeClock) NewTimer(d time.Duration) retrier.Timer {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	t := &fakeTimer{clock: c, at: c.now.Add(d), c: make(chan time.Time, 1)}
+	if c.autoAdvance {
+		if d > 0 {
+			c.now = t.at
+		}
+		t.c <- c.now
+		return t
+	}
+
+	if d <= 0 {
+		t.c <- c.now
+	} else {
+		c.timers = append(c.timers, t)
+		sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+	}
+	close(c.changed)
+	c.changed = make(chan struct{})
+	return t
+}
+
+// Advance moves the clock's time forward by d, firing every timer which is then due.
+func (c *FakeClock) Advance(d time.Duration) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.now = c.now.Add(d)
+	for len(c.timers) > 0 && !c.timers[0].at.After(c.now) {
+		c.timers[0].c <- c.now
+		c.timers = c.timers[1:]
+	}
+}
+
+// Timers returns the number of timers which have been created but have neither fired nor been stopped; i.e.
+// the number of goroutines currently waiting on the clock.
+func (c *FakeClock) Timers() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil waits until at least n timers are pending (see Timers). Tests can use this to wait for the code
+// under test to start waiting before calling Advance.
+func (c *FakeClock) BlockUntil(n int) {
+	for {
+		c.lock.Lock()
+		pending, changed := len(c.timers), c.changed
+		c.lock.Unlock()
+
+		if pending >= n {
+			return
+		}
+		<-changed
+	}
+}
+
+type fakeTimer struct {
+	clock *FakeClock
+	at    time.Time
+	c     chan time.Time
+}
+
+func (t *fakeTimer) C() <-chan time.Time {
+	return t.c
+}
+
+func (t *fakeTimer) Stop() bool {
+	t.clock.lock.Lock()
+	defer t.clock.lock.Unlock()
+
+	for i, timer := range t.clock.timers {
+		if timer == t {
+			t.clock.timers = append(t.clock.timers[:i], t.clock.timers[i+1:]...)
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,115 @@
+package retriertest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/eapache/go-resiliency/retrier"
+)
+
+var errFoo = errors.New("FOO")
+
+func failing(ctx context.Context) error {
+	return errFoo
+}
+
+func TestNew(t *testing.T) {
+	run := func() (retrier.History, time.Duration) {
+		r, clock := New(retrier.ExponentialBackoff(5, time.Minute), nil, 42)
+		r.SetJitter(0.5)
+		history, err := r.RunWithHistoryCtx(context.Background(), failing)
+		if err != errFoo {
+			t.Error(err)
+		}
+		return history, clock.Now().Sub(Epoch)
+	}
+
+	start := time.Now()
+	first, waited := run()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Error("run was not instant", elapsed)
+	}
+	if len(first) != 6 {
+		t.Fatal("wrong number of attempts", len(first))
+	}
+
+	var total time.Duration
+	for _, record := range first {
+		total += record.Backoff
+	}
+	if total != waited || waited < 15*time.Minute {
+		t.Error("clock did not advance by the back-offs", total, waited)
+	}
+
+	second, _ := run()
+	for i := range first {
+		if first[i].Backoff != second[i].Backoff || !first[i].Start.Equal(second[i].Start) {
+			t.Error("runs not deterministic", i, first[i], second[i])
+		}
+	}
+}
+
+func TestNewMaxElapsedTime(t *testing.T) {
+	r, clock := New(retrier.ConstantBackoff(100, time.Minute), nil, 1)
+	r.WithMaxElapsedTime(10 * time.Minute)
+
+	attempts := 0
+	r.RunCtx(context.Background(), func(ctx context.Context) error {
+		attempts++
+		return errFoo
+	})
+	if attempts != 11 || clock.Now().Sub(Epoch) != 10*time.Minute {
+		t.Error("wrong elapsed time", attempts, clock.Now().Sub(Epoch))
+	}
+}
+
+func TestFakeClock(t *testing.T) {
+	clock := NewFakeClock(Epoch)
+	r := retrier.New(retrier.ConstantBackoff(2, time.Hour), nil).WithClock(clock)
+
+	attempts := 0
+	done := make(chan error)
+	go func() {
+		done <- r.RunCtx(context.Background(), func(ctx context.Context) error {
+			attempts++
+			return errFoo
+		})
+	}()
+
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(30 * time.Minute)
+		if clock.Timers() != 1 {
+			t.Fatal("timer fired early")
+		}
+		clock.Advance(30 * time.Minute)
+	}
+	if err := <-done; err != errFoo || attempts != 3 {
+		t.Error(err, attempts)
+	}
+	if !clock.Now().Equal(Epoch.Add(2 * time.Hour)) {
+		t.Error("wrong time", clock.Now())
+	}
+}
+
+func TestFakeClockStop(t *testing.T) {
+	clock := NewFakeClock(Epoch)
+	r := retrier.New(retrier.ConstantBackoff(1, time.Hour), nil).WithClock(clock)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- r.RunCtx(ctx, failing)
+	}()
+
+	clock.BlockUntil(1)
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Error(err)
+	}
+	if clock.Timers() != 0 {
+		t.Error("cancelled sleep did not stop its timer")
+	}
+}
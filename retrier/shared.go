@@ -0,0 +1,56 @@
+package retrier
+
+import (
+	"context"
+	"runtime/debug"
+)
+
+// sharedRun is a run in progress for RunSharedCtx.
+type sharedRun struct {
+	done chan struct{}
+	err  error
+}
+
+// RunSharedCtx is like RunCtx, but concurrent calls with the same key are collapsed into a single run (including
+// all of its retries and back-offs), and every caller receives its result. A call made with a key for which
+// no run is in progress starts a new run, using its own context; the other callers stop waiting if their own
+// contexts are done, but cannot cancel the shared run. Keys are only shared between calls on the same retrier
+// (a clone starts afresh). This prevents many goroutines which need the same thing from all hammering a
+// struggling dependency at once. If the shared run panics, the panic propagates to the caller which started
+// it, and the other callers receive a *PanicError.
+func (r *Retrier) RunSharedCtx(ctx context.Context, key string, work func(ctx context.Context) error) error {
+	r.sharedMu.Lock()
+	if run, ok := r.shared[key]; ok {
+		r.sharedMu.Unlock()
+		select {
+		case <-run.done:
+			return run.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	run := &sharedRun{done: make(chan struct{})}
+	if r.shared == nil {
+		r.shared = make(map[string]*sharedRun)
+	}
+	r.shared[key] = run
+	r.sharedMu.Unlock()
+
+	defer func() {
+		recovered := recover()
+		if recovered != nil {
+			run.err = &PanicError{Value: recovered, Stack: debug.Stack()}
+		}
+		r.sharedMu.Lock()
+		delete(r.shared, key)
+		r.sharedMu.Unlock()
+		close(run.done)
+		if recovered != nil {
+			panic(recovered)
+		}
+	}()
+
+	run.err = r.RunCtx(ctx, work)
+	return run.err
+}
@@ -0,0 +1,121 @@
+package retrier
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetrierRunSharedCtx(t *testing.T) {
+	r := New([]time.Duration{time.Millisecond, time.Millisecond}, nil)
+
+	var calls int32
+	release := make(chan struct{})
+	work := func(ctx context.Context) error {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			<-release
+			return errFoo
+		}
+		return errBar
+	}
+
+	const callers = 10
+	errs := make(chan error, callers)
+	var wg sync.WaitGroup
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- r.RunSharedCtx(context.Background(), "key", work)
+		}()
+	}
+
+	// wait for every caller to join the shared run before letting it proceed
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	close(errs)
+
+	if calls != 3 {
+		t.Error("work not shared between callers", calls)
+	}
+	for err := range errs {
+		if err != errBar {
+			t.Error(err)
+		}
+	}
+
+	// different keys run separately, and finished runs are not reused
+	calls = 1
+	if err := r.RunSharedCtx(context.Background(), "other", work); err != errBar {
+		t.Error(err)
+	}
+	if calls != 4 {
+		t.Error("work not run", calls)
+	}
+}
+
+func TestRetrierRunSharedCtxCancelled(t *testing.T) {
+	r := New(nil, nil)
+
+	release := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- r.RunSharedCtx(context.Background(), "key", func(ctx context.Context) error {
+			<-release
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// a waiting caller gives up when its own context is done, without affecting the shared run
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := r.RunSharedCtx(ctx, "key", func(ctx context.Context) error { return errFoo }); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRetrierRunSharedCtxPanic(t *testing.T) {
+	r := New(nil, nil)
+
+	release := make(chan struct{})
+	panicked := make(chan any)
+	go func() {
+		defer func() { panicked <- recover() }()
+		_ = r.RunSharedCtx(context.Background(), "key", func(ctx context.Context) error {
+			<-release
+			panic("boom")
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	waiter := make(chan error)
+	go func() {
+		waiter <- r.RunSharedCtx(context.Background(), "key", func(ctx context.Context) error { return nil })
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+
+	// the caller running the work sees the panic itself, and the waiting caller gets an error (not success)
+	if p := <-panicked; p != "boom" {
+		t.Error("panic not propagated", p)
+	}
+	var panicErr *PanicError
+	if err := <-waiter; !errors.As(err, &panicErr) || panicErr.Value != "boom" {
+		t.Error(err)
+	}
+
+	// the key is free for a new run afterwards
+	if err := r.RunSharedCtx(context.Background(), "key", func(ctx context.Context) error { return nil }); err != nil {
+		t.Error(err)
+	}
+}
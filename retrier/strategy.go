@@ -0,0 +1,118 @@
+package retrier
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Strategy decides how long a Retrier backs off before each retry. Next is called with the retry number
+// (counting from 0) and the previous back-off actually used (0 before the first retry), and returns the base
+// back-off before jitter, or false if the work should not be retried again.
+type Strategy interface {
+	Next(attempt int, prev time.Duration) (time.Duration, bool)
+}
+
+// ContextStrategy is a Strategy which can also read the context of the run it is computing a back-off for,
+// e.g. to apply a per-tenant multiplier. A Retrier constructed with NewWithStrategy calls NextCtx (with the
+// context passed to RunCtx, including any values added WithContextValues) whenever its strategy implements
+// this interface; Next is only used where there is no run, such as by DryRun.
+type ContextStrategy interface {
+	Strategy
+	NextCtx(ctx context.Context, attempt int, prev time.Duration) (time.Duration, bool)
+}
+
+// ContextStrategyFunc adapts an ordinary function into a ContextStrategy. Its Next method calls the function
+// with context.Background().
+type ContextStrategyFunc func(ctx context.Context, attempt int, prev time.Duration) (time.Duration, bool)
+
+// Next implements Strategy.
+func (f ContextStrategyFunc) Next(attempt int, prev time.Duration) (time.Duration, bool) {
+	return f(context.Background(), attempt, prev)
+}
+
+// NextCtx implements ContextStrategy.
+func (f ContextStrategyFunc) NextCtx(ctx context.Context, attempt int, prev time.Duration) (time.Duration, bool) {
+	return f(ctx, attempt, prev)
+}
+
+// StrategyWithContext adapts any Strategy into a ContextStrategy. If s already implements ContextStrategy it
+// is returned unchanged; otherwise the result's NextCtx ignores the context and calls s.Next.
+func StrategyWithContext(s Strategy) ContextStrategy {
+	if cs, ok := s.(ContextStrategy); ok {
+		return cs
+	}
+	return contextFreeStrategy{s}
+}
+
+type contextFreeStrategy struct {
+	Strategy
+}
+
+func (s contextFreeStrategy) NextCtx(_ context.Context, attempt int, prev time.Duration) (time.Duration, bool) {
+	return s.Next(attempt, prev)
+}
+
+// OutcomeRecorder is an optional interface which a Strategy can implement to observe the outcome of every attempt
+// made by a Retrier constructed with NewWithStrategy. RecordOutcome is called after each attempt has been
+// classified, with true unless the classifier returned Succeed; it may be called concurrently if the strategy is
+// shared between Retriers or runs.
+type OutcomeRecorder interface {
+	RecordOutcome(failed bool)
+}
+
+// AdaptiveBackoff is a Strategy which scales a base back-off pattern by the recent health of the system it is
+// retrying against. It keeps an exponentially-weighted moving average of attempt failures; when every recent
+// attempt has failed the base back-off is multiplied by maxFactor, and when every recent attempt has succeeded it
+// is used unchanged. A single AdaptiveBackoff may be shared by any number of Retriers (and is safe for concurrent
+// use), in which case they all back off more when any of them sees the system struggling.
+type AdaptiveBackoff struct {
+	base      []time.Duration
+	maxFactor float64
+	alpha     float64
+
+	lock sync.Mutex
+	ewma float64
+}
+
+// NewAdaptiveBackoff constructs an AdaptiveBackoff which retries once per entry in base. The maxFactor is the
+// largest multiplier applied to the base back-off (values below 1 are treated as 1), and alpha, between 0 and 1, is
+// the weight given to each new outcome in the moving average.
+func NewAdaptiveBackoff(base []time.Duration, maxFactor, alpha float64) *AdaptiveBackoff {
+	if maxFactor < 1 {
+		maxFactor = 1
+	}
+	alpha = min(max(alpha, 0), 1)
+	return &AdaptiveBackoff{
+		base:      base,
+		maxFactor: maxFactor,
+		alpha:     alpha,
+	}
+}
+
+// Next implements Strategy.
+func (a *AdaptiveBackoff) Next(attempt int, _ time.Duration) (time.Duration, bool) {
+	if attempt >= len(a.base) {
+		return 0, false
+	}
+	return time.Duration(float64(a.base[attempt]) * a.Factor()), true
+}
+
+// Factor returns the multiplier currently applied to the base back-off, between 1 and maxFactor.
+func (a *AdaptiveBackoff) Factor() float64 {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	return 1 + (a.maxFactor-1)*a.ewma
+}
+
+// RecordOutcome implements OutcomeRecorder.
+func (a *AdaptiveBackoff) RecordOutcome(failed bool) {
+	sample := 0.0
+	if failed {
+		sample = 1
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	a.ewma += a.alpha * (sample - a.ewma)
+}
@@ -0,0 +1,182 @@
+package retrier
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+type stepStrategy struct {
+	retries int
+	step    time.Duration
+}
+
+func (s stepStrategy) Next(attempt int, prev time.Duration) (time.Duration, bool) {
+	if attempt >= s.retries {
+		return 0, false
+	}
+	return prev + s.step, true
+}
+
+func TestRetrierWithStrategy(t *testing.T) {
+	r := NewWithStrategy(stepStrategy{retries: 3, step: 10 * time.Millisecond}, nil)
+
+	expected := []time.Duration{10 * time.Millisecond, 20 * time.Millisecond, 30 * time.Millisecond}
+	sleeps := r.DryRun(10)
+	if len(sleeps) != len(expected) {
+		t.Fatal("wrong number of sleeps", sleeps)
+	}
+	for i := range expected {
+		if sleeps[i] != expected[i] {
+			t.Error("wrong sleep", i, sleeps[i])
+		}
+	}
+
+	r = NewWithStrategy(stepStrategy{retries: 2, step: time.Millisecond}, nil)
+	i := 0
+	err := r.Run(func() error {
+		i++
+		return errFoo
+	})
+	if err != errFoo {
+		t.Error(err)
+	}
+	if i != 3 {
+		t.Error("run wrong number of times", i)
+	}
+}
+
+func TestAdaptiveBackoff(t *testing.T) {
+	adaptive := NewAdaptiveBackoff([]time.Duration{time.Millisecond, time.Millisecond}, 10, 0.5)
+	healthy, _ := adaptive.Next(0, 0)
+	if healthy != time.Millisecond {
+		t.Error("healthy backoff was scaled", healthy)
+	}
+	if _, ok := adaptive.Next(2, 0); ok {
+		t.Error("adaptive backoff did not stop after its base pattern")
+	}
+
+	// two retriers sharing the adaptive state both see a burst of failures
+	r1 := NewWithStrategy(adaptive, nil)
+	r2 := NewWithStrategy(adaptive, nil)
+	fail := func() error { return errFoo }
+	succeed := func() error { return nil }
+
+	if err := r1.Run(fail); err != errFoo {
+		t.Error(err)
+	}
+	afterOne, _ := adaptive.Next(0, 0)
+	if afterOne <= healthy {
+		t.Error("backoff did not grow after failures", afterOne)
+	}
+	if err := r2.Run(fail); err != errFoo {
+		t.Error(err)
+	}
+	afterTwo, _ := adaptive.Next(0, 0)
+	if afterTwo <= afterOne {
+		t.Error("backoff did not grow after more failures", afterTwo)
+	}
+	if afterTwo > 10*time.Millisecond {
+		t.Error("backoff grew past the max factor", afterTwo)
+	}
+
+	prev := afterTwo
+	for i := 0; i < 5; i++ {
+		if err := r1.Run(succeed); err != nil {
+			t.Error(err)
+		}
+		if err := r2.Run(succeed); err != nil {
+			t.Error(err)
+		}
+		next, _ := adaptive.Next(0, 0)
+		if next >= prev {
+			t.Fatal("backoff did not shrink after successes", next)
+		}
+		prev = next
+	}
+	if adaptive.Factor() > 1.01 {
+		t.Error("backoff did not recover", adaptive.Factor())
+	}
+}
+
+// recordingStrategy is a user-defined strategy which observes the outcome of every attempt.
+type recordingStrategy struct {
+	stepStrategy
+	outcomes []bool
+}
+
+func (s *recordingStrategy) RecordOutcome(failed bool) {
+	s.outcomes = append(s.outcomes, failed)
+}
+
+func TestRetrierWithOutcomeRecorder(t *testing.T) {
+	strategy := &recordingStrategy{stepStrategy: stepStrategy{retries: 3}}
+	r := NewWithStrategy(strategy, nil)
+
+	if err := r.Run(genWork([]error{errFoo, errFoo})); err != nil {
+		t.Error(err)
+	}
+	if len(strategy.outcomes) != 3 || !strategy.outcomes[0] || !strategy.outcomes[1] || strategy.outcomes[2] {
+		t.Error("wrong outcomes recorded", strategy.outcomes)
+	}
+}
+
+type tenantKey struct{}
+
+func TestRetrierWithContextStrategy(t *testing.T) {
+	perTenant := ContextStrategyFunc(func(ctx context.Context, attempt int, prev time.Duration) (time.Duration, bool) {
+		if attempt >= 2 {
+			return 0, false
+		}
+		multiplier, ok := ctx.Value(tenantKey{}).(int)
+		if !ok {
+			multiplier = 1
+		}
+		return time.Duration(multiplier) * time.Millisecond, true
+	})
+	r := NewWithStrategy(perTenant, nil)
+
+	backoffs := func(ctx context.Context) []time.Duration {
+		history, err := r.RunWithHistoryCtx(ctx, func(ctx context.Context) error { return errFoo })
+		if err != errFoo {
+			t.Error(err)
+		}
+		var result []time.Duration
+		for _, record := range history[:len(history)-1] {
+			result = append(result, record.Backoff)
+		}
+		return result
+	}
+
+	small := backoffs(context.WithValue(context.Background(), tenantKey{}, 2))
+	large := backoffs(context.WithValue(context.Background(), tenantKey{}, 5))
+	if len(small) != 2 || small[0] != 2*time.Millisecond || small[1] != 2*time.Millisecond {
+		t.Error("wrong backoffs for small tenant", small)
+	}
+	if len(large) != 2 || large[0] != 5*time.Millisecond || large[1] != 5*time.Millisecond {
+		t.Error("wrong backoffs for large tenant", large)
+	}
+
+	// there is no run context for a dry run
+	if sleeps := r.DryRun(5); len(sleeps) != 2 || sleeps[0] != time.Millisecond {
+		t.Error("wrong dry run", sleeps)
+	}
+}
+
+func TestStrategyWithContext(t *testing.T) {
+	step := stepStrategy{retries: 1, step: time.Millisecond}
+	adapted := StrategyWithContext(step)
+	if d, ok := adapted.NextCtx(context.Background(), 0, time.Millisecond); !ok || d != 2*time.Millisecond {
+		t.Error("adapter changed the strategy", d, ok)
+	}
+	if _, ok := adapted.NextCtx(context.Background(), 1, 0); ok {
+		t.Error("adapter changed the strategy")
+	}
+
+	fn := ContextStrategyFunc(func(ctx context.Context, attempt int, prev time.Duration) (time.Duration, bool) {
+		return 0, true
+	})
+	if _, ok := StrategyWithContext(fn).(ContextStrategyFunc); !ok {
+		t.Error("context strategy was wrapped")
+	}
+}
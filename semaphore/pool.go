@@ -0,0 +1,195 @@
+package semaphore
+
+import (
+	"container/list"
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// errExpired is returned by a pool's acquire method when the given expiry channel fires.
+var errExpired = errors.New("expired")
+
+// pool is the interface implemented by the different ways a Semaphore can store its tickets.
+type pool interface {
+	// acquire waits for a ticket until one is available, the context is done (returning the context's
+	// error), or expired fires (returning errExpired). A nil expired channel waits forever. A ticket is
+	// held afterwards if and only if it returns nil, even if the context is done as the ticket is granted.
+	acquire(ctx context.Context, expired <-chan time.Time) error
+	// tryRelease returns a ticket to the pool, or returns false if no tickets are held.
+	tryRelease() bool
+	// held returns the number of tickets currently held.
+	held() int
+	// blocked returns the number of goroutines currently blocked in acquire.
+	blocked() int
+	// size returns the total number of tickets.
+	size() int
+}
+
+// chanPool is a pool implemented by a buffered channel, with one slot per ticket.
+type chanPool struct {
+	tickets chan struct{}
+	waiting atomic.Int64
+}
+
+func newChanPool(tickets int) *chanPool {
+	return &chanPool{tickets: make(chan struct{}, tickets)}
+}
+
+func (p *chanPool) acquire(ctx context.Context, expired <-chan time.Time) error {
+	select {
+	case p.tickets <- struct{}{}:
+		return nil
+	default:
+	}
+
+	p.waiting.Add(1)
+	defer p.waiting.Add(-1)
+
+	select {
+	case p.tickets <- struct{}{}:
+		return nil
+	case <-expired:
+		return errExpired
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (p *chanPool) tryRelease() bool {
+	select {
+	case <-p.tickets:
+		return true
+	default:
+		return false
+	}
+}
+
+func (p *chanPool) held() int {
+	return len(p.tickets)
+}
+
+func (p *chanPool) blocked() int {
+	return int(p.waiting.Load())
+}
+
+func (p *chanPool) size() int {
+	return cap(p.tickets)
+}
+
+// atomicPool is a pool implemented by an atomic counter, so that acquiring an available ticket or releasing
+// one with nobody waiting never blocks on anything. Goroutines which have to wait for a ticket park on
+// their own channel, and are handed a ticket directly by whichever goroutine releases one.
+type atomicPool struct {
+	capacity int64
+	count    atomic.Int64 // the number of tickets held
+	waiting  atomic.Int64 // the number of entries in waiters
+
+	lock    sync.Mutex
+	waiters list.List // of chan struct{}
+}
+
+func newAtomicPool(tickets int) *atomicPool {
+	return &atomicPool{capacity: int64(tickets)}
+}
+
+func (p *atomicPool) tryAcquire() bool {
+	for {
+		count := p.count.Load()
+		if count >= p.capacity {
+			return false
+		}
+		if p.count.CompareAndSwap(count, count+1) {
+			return true
+		}
+	}
+}
+
+func (p *atomicPool) acquire(ctx context.Context, expired <-chan time.Time) error {
+	if p.tryAcquire() {
+		return nil
+	}
+
+	p.lock.Lock()
+	// register as a waiter *before* trying again, so that any release we miss is guaranteed to
+	// see us waiting and hand its ticket over
+	ready := make(chan struct{}, 1)
+	elem := p.waiters.PushBack(ready)
+	p.waiting.Add(1)
+	if p.tryAcquire() {
+		p.dequeue(elem)
+		p.lock.Unlock()
+		return nil
+	}
+	p.lock.Unlock()
+
+	var err error
+	select {
+	case <-ready:
+		return nil
+	case <-expired:
+		err = errExpired
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	select {
+	case <-ready:
+		// we were handed a ticket while giving up, so take it after all
+		return nil
+	default:
+		p.dequeue(elem)
+		return err
+	}
+}
+
+func (p *atomicPool) tryRelease() bool {
+	for {
+		count := p.count.Load()
+		if count <= 0 {
+			return false
+		}
+		if p.count.CompareAndSwap(count, count-1) {
+			break
+		}
+	}
+
+	if p.waiting.Load() > 0 {
+		p.handoff()
+	}
+	return true
+}
+
+// handoff hands available tickets to waiting goroutines, in the order they started waiting.
+func (p *atomicPool) handoff() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for p.waiters.Len() > 0 && p.tryAcquire() {
+		elem := p.waiters.Front()
+		p.dequeue(elem)
+		elem.Value.(chan struct{}) <- struct{}{}
+	}
+}
+
+// dequeue removes a waiter; the lock must be held.
+func (p *atomicPool) dequeue(elem *list.Element) {
+	p.waiters.Remove(elem)
+	p.waiting.Add(-1)
+}
+
+func (p *atomicPool) held() int {
+	return int(p.count.Load())
+}
+
+func (p *atomicPool) blocked() int {
+	return int(p.waiting.Load())
+}
+
+func (p *atomicPool) size() int {
+	return int(p.capacity)
+}
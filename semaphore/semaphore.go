@@ -2,53 +2,345 @@
 package semaphore
 
 import (
+	"context"
 	"errors"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// ErrNoTickets is the error returned by Acquire when it could not acquire
+// ErrAcquireTimeout is the error returned by Acquire when it could not acquire
 // a ticket from the semaphore within the configured timeout.
-var ErrNoTickets = errors.New("could not acquire semaphore ticket")
+var ErrAcquireTimeout = errors.New("could not acquire semaphore ticket")
+
+// ErrNoTickets is the original name of ErrAcquireTimeout, and is kept as an alias of it
+// for backwards compatibility.
+var ErrNoTickets = ErrAcquireTimeout
+
+// nextID is used to give every Semaphore a unique id, which defines a consistent
+// order in which to acquire multiple semaphores in AcquireAll.
+var nextID uint64
 
 // Semaphore implements the semaphore resiliency pattern
 type Semaphore struct {
-	sem     chan struct{}
+	id      uint64
+	name    string
+	tickets pool
 	timeout time.Duration
+
+	leakAfter  time.Duration
+	onLeak     func(stack []byte)
+	leakLock   sync.Mutex
+	leakTimers []*time.Timer
+
+	counters counters
+}
+
+// SemaphoreStats is a snapshot of the state and counters of a semaphore, as returned by Stats.
+type SemaphoreStats struct {
+	Total     int // the number of tickets the semaphore has
+	InUse     int // the number of tickets currently held
+	Available int // the number of tickets currently free; always Total - InUse
+	Acquiring int // the number of goroutines currently inside a call which acquires tickets (see Stats)
+
+	TotalAcquired uint64 // the number of tickets acquired since the semaphore was created
+	TotalTimedOut uint64 // the number of acquisitions which failed with ErrAcquireTimeout
+}
+
+// counters tracks the numbers reported by Stats. Every update is bracketed by incrementing begun and ended, so
+// that a reader which sees ended (loaded first) equal to begun (loaded last) knows that no update was made while
+// it was reading, without updates ever having to wait for each other or for a reader.
+type counters struct {
+	begun, ended      atomic.Uint64
+	inUse, acquiring  atomic.Int64
+	acquired, timeout atomic.Uint64
+}
+
+// start counts a goroutine which has started trying to acquire tickets.
+func (c *counters) start() {
+	c.begun.Add(1)
+	c.acquiring.Add(1)
+	c.ended.Add(1)
+}
+
+// done counts a goroutine which was trying to acquire tickets having finished, by acquiring n tickets or by
+// timing out.
+func (c *counters) done(n int, timedOut bool) {
+	c.begun.Add(1)
+	c.acquiring.Add(-1)
+	c.inUse.Add(int64(n))
+	c.acquired.Add(uint64(n))
+	if timedOut {
+		c.timeout.Add(1)
+	}
+	c.ended.Add(1)
+}
+
+// release counts a released ticket.
+func (c *counters) release() {
+	c.begun.Add(1)
+	c.inUse.Add(-1)
+	c.ended.Add(1)
+}
+
+// snapshot reads all the counters at a single instant, retrying if they were updated while it was reading. The
+// retries are not bounded, but each update takes only a few atomic operations, so a reader can only be held
+// up while updates arrive back to back with no gap long enough for its handful of loads; yielding between
+// attempts gives the updating goroutines a chance to move on.
+func (c *counters) snapshot(total int) SemaphoreStats {
+	for {
+		ended := c.ended.Load()
+		inUse := int(c.inUse.Load())
+		stats := SemaphoreStats{
+			Total:         total,
+			InUse:         inUse,
+			Available:     total - inUse,
+			Acquiring:     int(c.acquiring.Load()),
+			TotalAcquired: c.acquired.Load(),
+			TotalTimedOut: c.timeout.Load(),
+		}
+		if c.begun.Load() == ended {
+			return stats
+		}
+		runtime.Gosched()
+	}
 }
 
 // New constructs a new Semaphore with the given ticket-count
 // and timeout.
 func New(tickets int, timeout time.Duration) *Semaphore {
 	return &Semaphore{
-		sem:     make(chan struct{}, tickets),
+		id:      atomic.AddUint64(&nextID, 1),
+		tickets: newChanPool(tickets),
 		timeout: timeout,
 	}
 }
 
+// NewAtomic is like New, but constructs a Semaphore which keeps track of its tickets with atomic operations
+// instead of a channel. Acquiring a ticket when one is available, and releasing a ticket when nobody is
+// waiting for one, are then just a few atomic operations, which can make a significant difference under
+// heavy contention. The resulting Semaphore behaves identically in every other respect.
+func NewAtomic(tickets int, timeout time.Duration) *Semaphore {
+	return &Semaphore{
+		id:      atomic.AddUint64(&nextID, 1),
+		tickets: newAtomicPool(tickets),
+		timeout: timeout,
+	}
+}
+
+// NewNamed constructs a new Semaphore with the given name, ticket-count and timeout.
+// The name is included in the errors returned by Acquire, which still match
+// ErrAcquireTimeout when compared with errors.Is.
+func NewNamed(name string, tickets int, timeout time.Duration) *Semaphore {
+	sem := New(tickets, timeout)
+	sem.name = name
+	return sem
+}
+
+// WithLeakDetector configures the semaphore to help track down tickets which are never released. Every time a
+// ticket is acquired, the stack of the acquiring goroutine is recorded, and if the ticket has not been released
+// within "after" then onLeak is called (in its own goroutine) with that stack. Since tickets are not tied to
+// the goroutine which acquired them, each Release is assumed to release the oldest outstanding ticket.
+// Capturing stacks is expensive, so this is intended as a debugging aid rather than for use in production.
+// It cannot safely be specified for a semaphore which is already in use.
+func (s *Semaphore) WithLeakDetector(after time.Duration, onLeak func(stack []byte)) *Semaphore {
+	s.leakAfter = after
+	s.onLeak = onLeak
+	return s
+}
+
 // Acquire tries to acquire a ticket from the semaphore. If it can, it returns nil.
-// If it cannot after "timeout" amount of time, it returns ErrNoTickets. It is
+// If it cannot after "timeout" amount of time, it returns ErrAcquireTimeout. It is
 // safe to call Acquire concurrently on a single Semaphore.
 func (s *Semaphore) Acquire() error {
+	return s.AcquireCtx(context.Background())
+}
+
+// AcquireCtx is like Acquire, but also gives up when the given context is done, in which case
+// it returns the context's error rather than ErrAcquireTimeout, so that a context deadline can be told
+// apart from the semaphore's own timeout. If a ticket is granted at the same moment the context is done,
+// AcquireCtx either keeps the ticket and returns nil or leaves it in the semaphore and returns the context's
+// error, never both, so a failed AcquireCtx never needs a matching Release. It is safe to call AcquireCtx
+// concurrently on a single Semaphore.
+func (s *Semaphore) AcquireCtx(ctx context.Context) error {
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+
+	s.counters.start()
+	if err := s.tickets.acquire(ctx, timer.C); err != nil {
+		s.counters.done(0, err == errExpired)
+		if err == errExpired {
+			return s.errAcquireTimeout()
+		}
+		return err
+	}
+	s.counters.done(1, false)
+	s.acquired()
+	return nil
+}
+
+// AcquireNWithProgress acquires n tickets from the semaphore, reserving them one at a time as they become
+// available and calling onProgress (if it is not nil) with the number reserved so far after each one, so that
+// the caller can report progress while it waits for the rest. If all n tickets cannot be reserved within
+// "timeout" amount of time, or the context is done first, the tickets already reserved are returned to the
+// semaphore and AcquireNWithProgress returns ErrAcquireTimeout or the context's error, as AcquireCtx does. On
+// success, each of the n tickets must be released separately. Since partially-reserved tickets are held while
+// waiting, concurrent callers which together need more tickets than the semaphore has can starve each other
+// until they time out.
+func (s *Semaphore) AcquireNWithProgress(ctx context.Context, n int, onProgress func(acquired int)) error {
 	timer := time.NewTimer(s.timeout)
-	select {
-	case s.sem <- struct{}{}:
-		timer.Stop()
-		return nil
-	case <-timer.C:
-		return ErrNoTickets
+	defer timer.Stop()
+
+	s.counters.start()
+	for acquired := 0; acquired < n; acquired++ {
+		if err := s.tickets.acquire(ctx, timer.C); err != nil {
+			for i := 0; i < acquired; i++ {
+				s.tickets.tryRelease()
+			}
+			s.counters.done(0, err == errExpired)
+			if err == errExpired {
+				return s.errAcquireTimeout()
+			}
+			return err
+		}
+		if onProgress != nil {
+			onProgress(acquired + 1)
+		}
 	}
+
+	s.counters.done(n, false)
+	for i := 0; i < n; i++ {
+		s.acquired()
+	}
+	return nil
+}
+
+// AcquireAll acquires a ticket from each of the given semaphores, or none of them. Tickets are always
+// acquired in the same order regardless of the order of the arguments, so concurrent calls to AcquireAll
+// with overlapping semaphores cannot deadlock. A semaphore passed more than once only has one ticket
+// acquired (and released), so duplicates cannot deadlock a semaphore with a single ticket. If any
+// acquisition fails (by timing out or because the context is done), the tickets already acquired are
+// released and the error is returned. On success, the returned function releases all of the tickets; it
+// must be called exactly once.
+func AcquireAll(ctx context.Context, sems ...*Semaphore) (release func(), err error) {
+	ordered := make([]*Semaphore, len(sems))
+	copy(ordered, sems)
+	sort.Slice(ordered, func(i, j int) bool {
+		return ordered[i].id < ordered[j].id
+	})
+	ordered = slices.Compact(ordered)
+
+	releaseAll := func(acquired []*Semaphore) {
+		for i := len(acquired) - 1; i >= 0; i-- {
+			acquired[i].Release()
+		}
+	}
+
+	for i, sem := range ordered {
+		if err := sem.AcquireCtx(ctx); err != nil {
+			releaseAll(ordered[:i])
+			return nil, err
+		}
+	}
+
+	return func() { releaseAll(ordered) }, nil
+}
+
+// AcquireBlocking acquires a ticket from the semaphore, waiting as long as necessary for one to
+// become available. Unlike Acquire, it ignores the configured timeout and never fails. It is safe to
+// call AcquireBlocking concurrently on a single Semaphore, but beware that if no ticket is ever
+// released (e.g. because every ticket holder is itself waiting on this goroutine) it will deadlock.
+func (s *Semaphore) AcquireBlocking() {
+	// a nil expiry channel never fires, and the background context is never done
+	s.counters.start()
+	_ = s.tickets.acquire(context.Background(), nil)
+	s.counters.done(1, false)
+	s.acquired()
 }
 
 // Release releases an acquired ticket back to the semaphore. It is safe to call
 // Release concurrently on a single Semaphore. It is an error to call Release on
-// a Semaphore from which you have not first acquired a ticket.
+// a Semaphore from which you have not first acquired a ticket, and doing so panics
+// (like unlocking an unlocked sync.Mutex).
 func (s *Semaphore) Release() {
-	<-s.sem
+	if !s.TryRelease() {
+		panic("semaphore: Release called with no tickets acquired")
+	}
+}
+
+// TryRelease is like Release, but returns false instead of panicking if no tickets are
+// currently acquired. It returns true if a ticket was released.
+func (s *Semaphore) TryRelease() bool {
+	if !s.tickets.tryRelease() {
+		return false
+	}
+	s.counters.release()
+	s.released()
+	return true
+}
+
+// acquired starts tracking a newly-acquired ticket if leak detection is enabled.
+func (s *Semaphore) acquired() {
+	if s.onLeak == nil {
+		return
+	}
+
+	stack := debug.Stack()
+	timer := time.AfterFunc(s.leakAfter, func() {
+		s.onLeak(stack)
+	})
+
+	s.leakLock.Lock()
+	defer s.leakLock.Unlock()
+	s.leakTimers = append(s.leakTimers, timer)
+}
+
+// released stops tracking the oldest outstanding ticket if leak detection is enabled.
+func (s *Semaphore) released() {
+	if s.onLeak == nil {
+		return
+	}
+
+	s.leakLock.Lock()
+	defer s.leakLock.Unlock()
+	if len(s.leakTimers) > 0 {
+		s.leakTimers[0].Stop()
+		s.leakTimers = s.leakTimers[1:]
+	}
+}
+
+func (s *Semaphore) errAcquireTimeout() error {
+	if s.name == "" {
+		return ErrAcquireTimeout
+	}
+	return fmt.Errorf("semaphore %q: %w", s.name, ErrAcquireTimeout)
 }
 
 // IsEmpty will return true if no tickets are being held at that instant.
 // It is safe to call concurrently with Acquire and Release, though do note
 // that the result may then be unpredictable.
 func (s *Semaphore) IsEmpty() bool {
-	return len(s.sem) == 0
+	return s.tickets.held() == 0
+}
+
+// Stats returns a consistent snapshot of the semaphore's state and counters, for exporting to metrics in one
+// read: every number in it was true at the same instant. Tickets count as in use once the call acquiring them
+// returns, and stop counting as soon as they are released. Acquiring counts every goroutine inside a call which
+// acquires tickets and has not yet returned, including one which is about to get a free ticket without waiting;
+// unlike WaitingCount, which counts only the goroutines actually blocked waiting for a ticket, it can therefore be
+// non-zero even while tickets are available. As with IsEmpty, the snapshot may be out of date as soon as it is
+// returned.
+func (s *Semaphore) Stats() SemaphoreStats {
+	return s.counters.snapshot(s.tickets.size())
+}
+
+// WaitingCount returns the number of goroutines blocked waiting for a ticket (in Acquire, AcquireCtx or
+// AcquireBlocking) at that instant. As with IsEmpty, the result may be out of date as soon as it is returned.
+func (s *Semaphore) WaitingCount() int {
+	return s.tickets.blocked()
 }
@@ -1,6 +1,11 @@
 package semaphore
 
 import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -45,6 +50,283 @@ func TestSemaphoreBlockTimeout(t *testing.T) {
 	}
 }
 
+func TestSemaphoreNamed(t *testing.T) {
+	sem := NewNamed("db-pool", 1, 10*time.Millisecond)
+
+	if err := sem.Acquire(); err != nil {
+		t.Error(err)
+	}
+
+	err := sem.Acquire()
+	if !errors.Is(err, ErrNoTickets) {
+		t.Error(err)
+	}
+	if !strings.Contains(err.Error(), `"db-pool"`) {
+		t.Error("error does not contain the semaphore name:", err)
+	}
+}
+
+func TestSemaphoreAcquireBlocking(t *testing.T) {
+	sem := New(1, 10*time.Millisecond)
+
+	sem.AcquireBlocking()
+
+	go func() {
+		// sleep well past the configured timeout before releasing
+		time.Sleep(50 * time.Millisecond)
+		sem.Release()
+	}()
+
+	start := time.Now()
+	sem.AcquireBlocking()
+	if time.Since(start) < 50*time.Millisecond {
+		t.Error("semaphore did not block until the ticket was released")
+	}
+
+	sem.Release()
+	if !sem.IsEmpty() {
+		t.Error("semaphore should be empty")
+	}
+}
+
+func TestSemaphoreAcquireCtx(t *testing.T) {
+	sem := New(1, 1*time.Second)
+
+	if err := sem.AcquireCtx(context.Background()); err != nil {
+		t.Error(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sem.AcquireCtx(ctx); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	sem = New(1, 10*time.Millisecond)
+	if err := sem.AcquireCtx(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if err := sem.AcquireCtx(context.Background()); err != ErrNoTickets {
+		t.Error(err)
+	}
+}
+
+func TestSemaphoreAcquireCtxErrors(t *testing.T) {
+	if ErrNoTickets != ErrAcquireTimeout {
+		t.Error("ErrNoTickets is not an alias of ErrAcquireTimeout")
+	}
+
+	sem := NewNamed("db-pool", 1, 10*time.Millisecond)
+	if err := sem.Acquire(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the semaphore's timeout is sooner
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := sem.AcquireCtx(ctx); !errors.Is(err, ErrAcquireTimeout) {
+		t.Error(err)
+	}
+
+	// the context's deadline is sooner
+	sem.timeout = time.Second
+	ctx, cancel = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := sem.AcquireCtx(ctx); err != context.DeadlineExceeded {
+		t.Error(err)
+	}
+
+	// the context is cancelled
+	ctx, cancel = context.WithCancel(context.Background())
+	cancel()
+	if err := sem.AcquireCtx(ctx); err != context.Canceled {
+		t.Error(err)
+	}
+}
+
+func TestSemaphoreAcquireNWithProgress(t *testing.T) {
+	for _, sem := range []*Semaphore{New(3, time.Second), NewAtomic(3, time.Second)} {
+		sem.AcquireBlocking()
+		sem.AcquireBlocking()
+
+		progress := make(chan int, 3)
+		done := make(chan error)
+		go func() {
+			done <- sem.AcquireNWithProgress(context.Background(), 3, func(acquired int) { progress <- acquired })
+		}()
+
+		if acquired := <-progress; acquired != 1 {
+			t.Error("wrong progress", acquired)
+		}
+		sem.Release()
+		if acquired := <-progress; acquired != 2 {
+			t.Error("wrong progress", acquired)
+		}
+		sem.Release()
+		if acquired := <-progress; acquired != 3 {
+			t.Error("wrong progress", acquired)
+		}
+		if err := <-done; err != nil {
+			t.Error(err)
+		}
+
+		for i := 0; i < 3; i++ {
+			sem.Release()
+		}
+		if !sem.IsEmpty() {
+			t.Error("semaphore should be empty")
+		}
+	}
+}
+
+func TestSemaphoreAcquireNWithProgressCancel(t *testing.T) {
+	for _, sem := range []*Semaphore{New(3, time.Second), NewAtomic(3, time.Second)} {
+		sem.AcquireBlocking()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		reserved := 0
+		err := sem.AcquireNWithProgress(ctx, 3, func(acquired int) {
+			reserved = acquired
+			if acquired == 2 {
+				cancel()
+			}
+		})
+		if err != context.Canceled || reserved != 2 {
+			t.Error(err, reserved)
+		}
+
+		// the reserved tickets were returned, leaving only the one held above
+		sem.Release()
+		if !sem.IsEmpty() {
+			t.Error("reserved tickets not returned")
+		}
+	}
+
+	sem := NewNamed("pool", 1, 10*time.Millisecond)
+	err := sem.AcquireNWithProgress(context.Background(), 2, nil)
+	if !errors.Is(err, ErrAcquireTimeout) || !sem.IsEmpty() {
+		t.Error("timed out acquire not rolled back", err)
+	}
+}
+
+func TestSemaphoreAcquireAll(t *testing.T) {
+	a := New(1, 10*time.Millisecond)
+	b := New(2, 10*time.Millisecond)
+	c := New(1, 10*time.Millisecond)
+
+	release, err := AcquireAll(context.Background(), c, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a.IsEmpty() || b.IsEmpty() || c.IsEmpty() {
+		t.Error("ticket not acquired")
+	}
+	release()
+	if !a.IsEmpty() || !b.IsEmpty() || !c.IsEmpty() {
+		t.Error("ticket not released")
+	}
+
+	// duplicates only take one ticket, even from a semaphore with just one
+	release, err = AcquireAll(context.Background(), a, b, a, b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats := b.Stats(); stats.InUse != 1 {
+		t.Error("duplicate acquired twice", stats)
+	}
+	release()
+	if !a.IsEmpty() || !b.IsEmpty() {
+		t.Error("ticket not released")
+	}
+
+	// opposite orders do not deadlock
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sems := []*Semaphore{a, c}
+			if i%2 == 0 {
+				sems = []*Semaphore{c, a}
+			}
+			sem := New(1, 1*time.Second)
+			for {
+				release, err := AcquireAll(context.Background(), append(sems, sem)...)
+				if err == nil {
+					release()
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+}
+
+func TestSemaphoreAcquireAllRollback(t *testing.T) {
+	a := New(1, 10*time.Millisecond)
+	b := New(1, 10*time.Millisecond)
+	c := New(1, 10*time.Millisecond)
+
+	if err := c.Acquire(); err != nil {
+		t.Error(err)
+	}
+
+	release, err := AcquireAll(context.Background(), a, b, c)
+	if err != ErrNoTickets || release != nil {
+		t.Error(err)
+	}
+	if !a.IsEmpty() || !b.IsEmpty() {
+		t.Error("acquired tickets were not rolled back")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	c.timeout = 1 * time.Second
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		cancel()
+	}()
+	release, err = AcquireAll(ctx, a, b, c)
+	if err != context.Canceled || release != nil {
+		t.Error(err)
+	}
+	if !a.IsEmpty() || !b.IsEmpty() {
+		t.Error("acquired tickets were not rolled back")
+	}
+}
+
+func TestSemaphoreLeakDetector(t *testing.T) {
+	leaks := make(chan []byte, 2)
+	sem := New(2, 10*time.Millisecond).WithLeakDetector(20*time.Millisecond, func(stack []byte) {
+		leaks <- stack
+	})
+
+	// released in time
+	if err := sem.Acquire(); err != nil {
+		t.Error(err)
+	}
+	sem.Release()
+
+	// leaked
+	if err := sem.Acquire(); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case stack := <-leaks:
+		if !strings.Contains(string(stack), "TestSemaphoreLeakDetector") {
+			t.Error("stack does not contain the acquiring function:", string(stack))
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatal("leak not detected")
+	}
+
+	select {
+	case <-leaks:
+		t.Error("released ticket reported as leaked")
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
 func TestSemaphoreEmpty(t *testing.T) {
 	sem := New(2, 200*time.Millisecond)
 
@@ -65,6 +347,297 @@ func TestSemaphoreEmpty(t *testing.T) {
 	}
 }
 
+func TestSemaphoreAtomic(t *testing.T) {
+	sem := NewAtomic(2, 20*time.Millisecond)
+
+	if err := sem.Acquire(); err != nil {
+		t.Error(err)
+	}
+	if err := sem.AcquireCtx(context.Background()); err != nil {
+		t.Error(err)
+	}
+	if sem.IsEmpty() {
+		t.Error("semaphore should not be empty")
+	}
+
+	start := time.Now()
+	if err := sem.Acquire(); err != ErrNoTickets {
+		t.Error(err)
+	}
+	if time.Since(start) < 20*time.Millisecond {
+		t.Error("semaphore did not wait long enough")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := sem.AcquireCtx(ctx); err != context.Canceled {
+		t.Error(err)
+	}
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		sem.Release()
+	}()
+	sem.AcquireBlocking()
+
+	sem.Release()
+	sem.Release()
+	if !sem.IsEmpty() {
+		t.Error("semaphore should be empty")
+	}
+}
+
+func TestSemaphoreAtomicNeverOverIssues(t *testing.T) {
+	const tickets = 3
+	sem := NewAtomic(tickets, time.Second)
+
+	var lock sync.Mutex
+	var held, maxHeld int
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				sem.AcquireBlocking()
+				lock.Lock()
+				held++
+				if held > maxHeld {
+					maxHeld = held
+				}
+				lock.Unlock()
+
+				lock.Lock()
+				held--
+				lock.Unlock()
+				sem.Release()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if maxHeld > tickets {
+		t.Error("semaphore issued too many tickets:", maxHeld)
+	}
+	if !sem.IsEmpty() {
+		t.Error("semaphore should be empty")
+	}
+}
+
+func TestSemaphoreAcquireCtxCancelRace(t *testing.T) {
+	const tickets = 2
+	for _, sem := range []*Semaphore{New(tickets, time.Second), NewAtomic(tickets, time.Second)} {
+		var held atomic.Int64
+
+		var wg sync.WaitGroup
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 200; j++ {
+					ctx, cancel := context.WithCancel(context.Background())
+					go cancel()
+					err := sem.AcquireCtx(ctx)
+					if err == nil {
+						if held.Add(1) > tickets {
+							t.Error("semaphore issued too many tickets")
+						}
+						held.Add(-1)
+						sem.Release()
+					} else if err != context.Canceled {
+						t.Error(err)
+					}
+					cancel()
+				}
+			}()
+		}
+		wg.Wait()
+
+		if !sem.IsEmpty() {
+			t.Error("semaphore leaked a ticket")
+		}
+		for i := 0; i < tickets; i++ {
+			if err := sem.AcquireCtx(context.Background()); err != nil {
+				t.Error("semaphore lost a ticket:", err)
+			}
+		}
+	}
+}
+
+func TestSemaphoreWaitingCount(t *testing.T) {
+	for _, sem := range []*Semaphore{New(1, time.Second), NewAtomic(1, time.Second)} {
+		awaitWaiting := func(n int) {
+			deadline := time.Now().Add(time.Second)
+			for sem.WaitingCount() != n {
+				if time.Now().After(deadline) {
+					t.Fatal("wrong waiting count", sem.WaitingCount(), "expected", n)
+				}
+				time.Sleep(time.Millisecond)
+			}
+		}
+
+		if err := sem.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		awaitWaiting(0)
+
+		acquired := make(chan struct{})
+		for i := 0; i < 3; i++ {
+			go func() {
+				if err := sem.Acquire(); err != nil {
+					t.Error(err)
+				}
+				acquired <- struct{}{}
+			}()
+		}
+		awaitWaiting(3)
+
+		for i := 2; i >= 0; i-- {
+			sem.Release()
+			<-acquired
+			awaitWaiting(i)
+		}
+		sem.Release()
+	}
+}
+
+func TestSemaphoreStats(t *testing.T) {
+	for _, sem := range []*Semaphore{New(2, 10*time.Millisecond), NewAtomic(2, 10*time.Millisecond)} {
+		if stats := sem.Stats(); stats != (SemaphoreStats{Total: 2, Available: 2}) {
+			t.Error("wrong initial stats", stats)
+		}
+
+		sem.AcquireBlocking()
+		if err := sem.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		if err := sem.Acquire(); err != ErrAcquireTimeout {
+			t.Error(err)
+		}
+		stats := sem.Stats()
+		if stats.InUse != 2 || stats.Available != 0 || stats.TotalAcquired != 2 || stats.TotalTimedOut != 1 {
+			t.Error("wrong stats while full", stats)
+		}
+
+		sem.Release()
+		if err := sem.AcquireCtx(context.Background()); err != nil {
+			t.Error(err)
+		}
+		sem.Release()
+		sem.Release()
+
+		stats = sem.Stats()
+		if stats.InUse != 0 || stats.Available != 2 || stats.Acquiring != 0 || stats.TotalAcquired != 3 || stats.TotalTimedOut != 1 {
+			t.Error("wrong final stats", stats)
+		}
+		if stats.InUse+stats.Available != stats.Total {
+			t.Error("inconsistent stats", stats)
+		}
+	}
+}
+
+func TestSemaphoreStatsConsistent(t *testing.T) {
+	const workers = 8
+	for _, sem := range []*Semaphore{New(2, time.Millisecond), NewAtomic(2, time.Millisecond)} {
+		stop := make(chan struct{})
+		var wg sync.WaitGroup
+		var acquired, timedOut atomic.Uint64
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-stop:
+						return
+					default:
+					}
+					if err := sem.Acquire(); err != nil {
+						timedOut.Add(1)
+						continue
+					}
+					acquired.Add(1)
+					sem.Release()
+				}
+			}()
+		}
+
+		var prev SemaphoreStats
+		for i := 0; i < 1000; i++ {
+			stats := sem.Stats()
+			if stats.InUse < 0 || stats.InUse > stats.Total || stats.InUse > int(stats.TotalAcquired) ||
+				stats.Acquiring < 0 || stats.Acquiring > workers || stats.InUse+stats.Acquiring > workers {
+				t.Fatal("inconsistent stats", stats)
+			}
+			if stats.TotalAcquired < prev.TotalAcquired || stats.TotalTimedOut < prev.TotalTimedOut {
+				t.Fatal("counters went backwards", prev, stats)
+			}
+			prev = stats
+		}
+		close(stop)
+		wg.Wait()
+
+		stats := sem.Stats()
+		if stats.InUse != 0 || stats.Acquiring != 0 || stats.TotalAcquired != acquired.Load() || stats.TotalTimedOut != timedOut.Load() {
+			t.Error("wrong final stats", stats, acquired.Load(), timedOut.Load())
+		}
+	}
+}
+
+func TestSemaphoreOverRelease(t *testing.T) {
+	for _, sem := range []*Semaphore{New(2, time.Second), NewAtomic(2, time.Second)} {
+		if sem.TryRelease() {
+			t.Error("released a ticket which was never acquired")
+		}
+
+		if err := sem.Acquire(); err != nil {
+			t.Fatal(err)
+		}
+		if !sem.TryRelease() {
+			t.Error("failed to release an acquired ticket")
+		}
+		if !sem.IsEmpty() {
+			t.Error("semaphore should be empty")
+		}
+
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("over-release did not panic")
+				}
+			}()
+			sem.Release()
+		}()
+
+		// the semaphore still works afterwards
+		if err := sem.Acquire(); err != nil {
+			t.Error(err)
+		}
+		sem.Release()
+		if !sem.IsEmpty() {
+			t.Error("semaphore should be empty")
+		}
+	}
+}
+
+func benchmarkSemaphore(b *testing.B, sem *Semaphore) {
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			sem.AcquireBlocking()
+			sem.Release()
+		}
+	})
+}
+
+func BenchmarkSemaphoreChannel(b *testing.B) {
+	benchmarkSemaphore(b, New(4, time.Second))
+}
+
+func BenchmarkSemaphoreAtomic(b *testing.B) {
+	benchmarkSemaphore(b, NewAtomic(4, time.Second))
+}
+
 func ExampleSemaphore() {
 	sem := New(3, 1*time.Second)
 